@@ -0,0 +1,513 @@
+package umeshu
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMultipartMemory is the maximum bytes of multipart form kept
+// in memory, the rest is stored in temporary files.
+const defaultMultipartMemory = 32 << 20
+
+// FieldError is the error of binding a value to a struct field.
+type FieldError struct {
+	Field  string // path of struct field, i.e. "Address.City"
+	Source string // "json", "form", "query", "uri" or "header"
+	Key    string // name of the value in source, i.e. query key
+	Value  string // value failed to bind, multiple values are joined by ","
+	Err    error
+}
+
+// Error returns the description of error.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %q of field %s: %s", e.Source, e.Key, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// BindingErrors collects errors of binding values to struct fields.
+type BindingErrors []*FieldError
+
+// Error returns the description of all errors.
+func (e BindingErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d binding errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Bind binds route parameters, query string, headers and request body to
+// obj, which must be a pointer to struct, by struct tags "uri", "query",
+// "header" and the tag of body, in that order so that latter ones win.
+// Body is decoded according to Content-Type, JSON by "json" tags and
+// url-encoded or multipart form by "form" tags.
+//
+// Fields can be string, bool, numeric, time.Time, time.Duration, types
+// implementing encoding.TextUnmarshaler, or slices or pointers of them.
+// time.Time is parsed in RFC3339 unless "time_format" tag is given, i.e.
+// `query:"since" time_format:"2006-01-02"`, "unix" for unix time in seconds.
+// Fields of nested and embedded structs without the tag are bound as well.
+//
+// All values failed to bind are reported by BindingErrors, other errors,
+// i.e. malformed body, are returned as it is. obj is validated by
+// (*Context).Validate once it is bound, see (*Context).FailBinding for
+// responding these errors.
+func (c *Context) Bind(obj interface{}) error {
+	binders := []func(interface{}) error{c.bindURI, c.bindQuery, c.bindHeader}
+	if hasBody(c.Request) {
+		binders = append(binders, c.bindBody)
+	}
+	return c.bindAndValidate(obj, binders...)
+}
+
+// bindAndValidate binds obj by binders and validates it if it is a
+// struct, errors of binders are collected in BindingErrors.
+func (c *Context) bindAndValidate(obj interface{}, binders ...func(interface{}) error) error {
+	var errs BindingErrors
+	for _, bind := range binders {
+		err := bind(obj)
+		if fieldErrs, ok := err.(BindingErrors); ok {
+			errs = append(errs, fieldErrs...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return c.Validate(obj)
+}
+
+// bindBody binds request body by decoder chosen according to Content-Type.
+func (c *Context) bindBody(obj interface{}) error {
+	contentType := c.Request.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return c.bindJSON(obj)
+	case mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data":
+		return c.bindForm(obj)
+	}
+	return fmt.Errorf("unsupported content type %q", contentType)
+}
+
+// BindJSON decodes JSON request body to obj, see (*Context).Bind.
+// Value of mismatched type is reported by BindingErrors.
+func (c *Context) BindJSON(obj interface{}) error {
+	return c.bindAndValidate(obj, c.bindJSON)
+}
+
+func (c *Context) bindJSON(obj interface{}) error {
+	if c.Request.Body == nil {
+		return errors.New("request body is empty")
+	}
+
+	err := json.NewDecoder(c.Request.Body).Decode(obj)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		return BindingErrors{{
+			Field:  jsonFieldPath(reflect.TypeOf(obj), e.Field),
+			Source: "json",
+			Key:    e.Field,
+			Value:  e.Value,
+			Err:    fmt.Errorf("cannot unmarshal %s into %s", e.Value, e.Type),
+		}}
+	}
+	if err == io.EOF {
+		return errors.New("request body is empty")
+	}
+	return fmt.Errorf("invalid JSON body: %w", err)
+}
+
+// BindForm binds url-encoded or multipart form of request body to obj
+// by "form" tags, see (*Context).Bind. Uploaded files are bound to
+// fields of *multipart.FileHeader or []*multipart.FileHeader.
+func (c *Context) BindForm(obj interface{}) error {
+	return c.bindAndValidate(obj, c.bindForm)
+}
+
+func (c *Context) bindForm(obj interface{}) error {
+	r := c.Request
+	if err := r.ParseMultipartForm(defaultMultipartMemory); err != nil && err != http.ErrNotMultipart {
+		return fmt.Errorf("invalid form body: %w", err)
+	}
+
+	src := valueSource{tag: "form", get: func(key string) []string { return r.PostForm[key] }}
+	if r.MultipartForm != nil {
+		src.files = r.MultipartForm.File
+	}
+	return bindValues(obj, src)
+}
+
+// BindQuery binds query string to obj by "query" tags, see (*Context).Bind.
+func (c *Context) BindQuery(obj interface{}) error {
+	return c.bindAndValidate(obj, c.bindQuery)
+}
+
+func (c *Context) bindQuery(obj interface{}) error {
+	query := c.Request.URL.Query()
+	return bindValues(obj, valueSource{tag: "query", get: func(key string) []string { return query[key] }})
+}
+
+// BindURI binds route parameters to obj by "uri" tags, see (*Context).Bind.
+func (c *Context) BindURI(obj interface{}) error {
+	return c.bindAndValidate(obj, c.bindURI)
+}
+
+func (c *Context) bindURI(obj interface{}) error {
+	return bindValues(obj, valueSource{tag: "uri", get: func(key string) []string {
+		if value, ok := c.RouteParams.Get(key); ok {
+			return []string{value}
+		}
+		return nil
+	}})
+}
+
+// BindHeader binds request headers to obj by "header" tags, see (*Context).Bind.
+func (c *Context) BindHeader(obj interface{}) error {
+	return c.bindAndValidate(obj, c.bindHeader)
+}
+
+func (c *Context) bindHeader(obj interface{}) error {
+	return bindValues(obj, valueSource{tag: "header", get: c.Request.Header.Values})
+}
+
+// hasBody reports whether the request may have a body.
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// valueSource provides values of the struct tag for binding.
+type valueSource struct {
+	tag   string
+	get   func(key string) []string
+	files map[string][]*multipart.FileHeader
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	fileHeaderType    = reflect.TypeOf((*multipart.FileHeader)(nil))
+	textUnmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// bindValues binds values of src to fields of obj, which must be
+// a pointer to struct.
+func bindValues(obj interface{}, src valueSource) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binding target must be a non-nil pointer to struct, got %T", obj)
+	}
+
+	var errs BindingErrors
+	bindStruct(v.Elem(), "", src, nil, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// bindStruct binds values of src to fields of struct v, prefix is the
+// path of v and parents are types of structs containing v. It reports
+// whether any field is set.
+func bindStruct(v reflect.Value, prefix string, src valueSource, parents []reflect.Type, errs *BindingErrors) bool {
+	set := false
+	t := v.Type()
+	parents = append(parents, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		field := v.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue // unexported
+		}
+
+		key := sf.Tag.Get(src.tag)
+		if idx := strings.IndexByte(key, ','); idx >= 0 {
+			key = key[:idx]
+		}
+		if key == "-" {
+			continue
+		}
+
+		path := prefix + sf.Name
+		if key == "" {
+			if isNestedStruct(sf.Type) && !isRecursive(sf.Type, parents) {
+				if sf.Anonymous {
+					path = prefix // fields are promoted
+				} else {
+					path += "."
+				}
+				// fields of embedded struct of unexported type can be set
+				set = bindNested(field, path, src, parents, errs) || set
+			}
+			continue
+		}
+		if !field.CanSet() {
+			continue
+		}
+
+		if src.files != nil && (sf.Type == fileHeaderType || sf.Type == reflect.SliceOf(fileHeaderType)) {
+			if files := src.files[key]; len(files) > 0 {
+				if sf.Type == fileHeaderType {
+					field.Set(reflect.ValueOf(files[0]))
+				} else {
+					field.Set(reflect.ValueOf(files))
+				}
+				set = true
+			}
+			continue
+		}
+
+		values := src.get(key)
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(field, values, sf.Tag); err != nil {
+			*errs = append(*errs, &FieldError{
+				Field:  path,
+				Source: src.tag,
+				Key:    key,
+				Value:  strings.Join(values, ","),
+				Err:    err,
+			})
+			continue
+		}
+		set = true
+	}
+	return set
+}
+
+// bindNested binds values of src to nested struct or pointer to struct,
+// nil pointer is allocated only if any field is set.
+func bindNested(field reflect.Value, prefix string, src valueSource, parents []reflect.Type, errs *BindingErrors) bool {
+	if field.Kind() != reflect.Ptr {
+		return bindStruct(field, prefix, src, parents, errs)
+	}
+	if !field.IsNil() {
+		return bindStruct(field.Elem(), prefix, src, parents, errs)
+	}
+	if !field.CanSet() {
+		return false
+	}
+
+	v := reflect.New(field.Type().Elem())
+	if !bindStruct(v.Elem(), prefix, src, parents, errs) {
+		return false
+	}
+	field.Set(v)
+	return true
+}
+
+// isNestedStruct reports whether t is a struct or pointer to struct whose
+// fields are bound one by one, rather than a value like time.Time.
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PtrTo(t).Implements(textUnmarshalType)
+}
+
+// isRecursive reports whether struct or pointer to struct t is one of
+// parents, i.e. Parent *Node of Node, which is not bound to avoid
+// infinite recursion.
+func isRecursive(t reflect.Type, parents []reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, parent := range parents {
+		if t == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// setField sets values to field, all values are set to slice field and
+// the first one to others.
+func setField(field reflect.Value, values []string, tag reflect.StructTag) error {
+	if reflect.PtrTo(field.Type()).Implements(textUnmarshalType) {
+		return setValue(field, values[0], tag)
+	}
+
+	switch field.Kind() {
+	case reflect.Ptr:
+		v := reflect.New(field.Type().Elem())
+		if err := setField(v.Elem(), values, tag); err != nil {
+			return err
+		}
+		field.Set(v)
+		return nil
+	case reflect.Slice:
+		s := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(s.Index(i), value, tag); err != nil {
+				return err
+			}
+		}
+		field.Set(s)
+		return nil
+	}
+	return setValue(field, values[0], tag)
+}
+
+// setValue parses s and sets it to v, zero value is set if s is empty.
+func setValue(v reflect.Value, s string, tag reflect.StructTag) error {
+	if s == "" && v.Kind() != reflect.String {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		return setTime(v, s, tag.Get("time_format"))
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// setTime parses s in layout and sets it to v, layout is RFC3339 if
+// empty and "unix" for unix time in seconds.
+func setTime(v reflect.Value, s string, layout string) error {
+	if layout == "unix" {
+		sec, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(time.Unix(sec, 0)))
+		return nil
+	}
+
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// jsonFieldPath converts path of JSON keys reported by encoding/json,
+// i.e. "address.city", to path of struct fields of t, i.e. "Address.City".
+// path is returned as it is if it can not be converted.
+func jsonFieldPath(t reflect.Type, path string) string {
+	if path == "" {
+		return path
+	}
+
+	var names []string
+	for _, key := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return path
+		}
+		sf, ok := jsonField(t, key)
+		if !ok {
+			return path
+		}
+		names = append(names, sf.Name)
+		t = sf.Type
+	}
+	return strings.Join(names, ".")
+}
+
+// jsonField returns the field of struct t decoded from JSON key,
+// including fields promoted from embedded structs.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := sf.Tag.Get("json")
+		if idx := strings.IndexByte(name, ','); idx >= 0 {
+			name = name[:idx]
+		}
+		if name == "-" {
+			continue
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			if promoted, ok := jsonField(ft, key); ok {
+				return promoted, true
+			}
+			continue
+		}
+		if name == key || (name == "" && strings.EqualFold(sf.Name, key)) {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
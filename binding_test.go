@@ -0,0 +1,118 @@
+package umeshu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTestAddress struct {
+	City string `json:"city" form:"city"`
+}
+
+type bindTestUser struct {
+	ID      int64         `uri:"id"`
+	Page    *int          `query:"page"`
+	Tags    []string      `query:"tag"`
+	Since   time.Time     `query:"since" time_format:"2006-01-02"`
+	Timeout time.Duration `query:"timeout"`
+	Token   string        `header:"X-Token"`
+	Name    string        `json:"name" form:"name"`
+	Admin   bool          `json:"admin" form:"admin"`
+	Scores  []float64     `json:"scores" form:"score"`
+	Address *bindTestAddress
+}
+
+func TestContext_Bind(t *testing.T) {
+	e := New()
+	e.POST("/users/:id", func(c *Context) {
+		var user bindTestUser
+		if err := c.Bind(&user); err != nil {
+			c.String(http.StatusBadRequest, "%s", err)
+			return
+		}
+		page := 0
+		if user.Page != nil {
+			page = *user.Page
+		}
+		city := ""
+		if user.Address != nil {
+			city = user.Address.City
+		}
+		c.String(http.StatusOK, "%d %d %v %s %s %s %s %t %v %s", user.ID, page, user.Tags,
+			user.Since.Format("2006-01-02"), user.Timeout, user.Token, user.Name, user.Admin, user.Scores, city)
+	})
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		code        int
+		want        string
+	}{
+		{"no body", "/users/42?page=2&tag=a&tag=b&since=2021-10-01&timeout=1m", "", "",
+			http.StatusOK, "42 2 [a b] 2021-10-01 1m0s token  false [] "},
+		{"json", "/users/42", "application/json", `{"name":"alice","admin":true,"scores":[1.5,2],"Address":{"city":"Tokyo"}}`,
+			http.StatusOK, "42 0 [] 0001-01-01 0s token alice true [1.5 2] Tokyo"},
+		{"form", "/users/42", "application/x-www-form-urlencoded", "name=bob&admin=1&score=3&score=4&city=Osaka",
+			http.StatusOK, "42 0 [] 0001-01-01 0s token bob true [3 4] Osaka"},
+		{"invalid values", "/users/x?page=two", "", "",
+			http.StatusBadRequest, `2 binding errors: uri "id" of field ID: strconv.ParseInt: parsing "x": invalid syntax; query "page" of field Page: strconv.ParseInt: parsing "two": invalid syntax`},
+		{"json type", "/users/42", "application/json", `{"address":{"city":1}}`,
+			http.StatusBadRequest, `1 binding errors: json "address.city" of field Address.City: cannot unmarshal number into string`},
+		{"malformed json", "/users/42", "application/json", `{"name":`,
+			http.StatusBadRequest, "invalid JSON body: unexpected EOF"},
+		{"unsupported", "/users/42", "text/csv", "name\nalice",
+			http.StatusBadRequest, `unsupported content type "text/csv"`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("X-Token", "token")
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, r)
+		if rw.Code != tt.code || rw.Body.String() != tt.want {
+			t.Errorf("%s: (%d, %q), want (%d, %q)", tt.name, rw.Code, rw.Body.String(), tt.code, tt.want)
+		}
+	}
+}
+
+type bindTestNode struct {
+	Name   string `query:"name"`
+	Parent *bindTestNode
+	Peers  struct {
+		Next *bindTestNode
+	}
+}
+
+func TestContext_BindRecursive(t *testing.T) {
+	e := New()
+	e.GET("/nodes", func(c *Context) {
+		var node bindTestNode
+		if err := c.BindQuery(&node); err != nil {
+			c.String(http.StatusBadRequest, "%s", err)
+			return
+		}
+
+		// cyclic pointers are not followed either
+		cyclic := &bindTestNode{}
+		cyclic.Parent, cyclic.Peers.Next = cyclic, cyclic
+		if err := c.bindQuery(cyclic); err != nil {
+			c.String(http.StatusBadRequest, "%s", err)
+			return
+		}
+		c.String(http.StatusOK, "%s %t %t %s", node.Name, node.Parent == nil, node.Peers.Next == nil, cyclic.Name)
+	})
+
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/nodes?name=root", nil))
+	if want := "root true true root"; rw.Code != http.StatusOK || rw.Body.String() != want {
+		t.Errorf("bind recursive struct: (%d, %q), want (%d, %q)", rw.Code, rw.Body.String(), http.StatusOK, want)
+	}
+}
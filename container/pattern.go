@@ -0,0 +1,363 @@
+package container
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// constraint checks if a parameter value is acceptable.
+type constraint func(value string) bool
+
+// builtinConstraints are constraints which can be referred by name,
+// i.e. ":id<int>". Other constraints are treated as regular expression
+// which must match the whole value, i.e. ":slug<[a-z0-9-]+>".
+var builtinConstraints = map[string]constraint{
+	"int":   isInt,
+	"uint":  isUint,
+	"alpha": isAlpha,
+	"alnum": isAlnum,
+	"uuid":  isUUID,
+}
+
+// constraintCache caches compiled constraints of regular expression.
+var constraintCache sync.Map
+
+// newConstraint returns the constraint according to expr,
+// nil if expr is empty.
+func newConstraint(expr string) (constraint, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if c, ok := builtinConstraints[expr]; ok {
+		return c, nil
+	}
+	if c, ok := constraintCache.Load(expr); ok {
+		return c.(constraint), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	c := constraint(re.MatchString)
+	constraintCache.Store(expr, c)
+	return c, nil
+}
+
+func isUint(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isInt(value string) bool {
+	if value != "" && (value[0] == '-' || value[0] == '+') {
+		value = value[1:]
+	}
+	return isUint(value)
+}
+
+func isAlpha(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if !isLetter(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if !isLetter(value[i]) && (value[i] < '0' || value[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isUUID checks value in the form of "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx".
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if value[i] != '-' {
+				return false
+			}
+		default:
+			c := value[i]
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tokenEnd returns the length of the parameter or wildcard token at the
+// beginning of path, i.e. ":id<int>" of ":id<int>/posts" and ":name" of
+// ":name.:ext". Name consists of letters, digits and '_', parameter name
+// may be followed by constraint enclosed by '<' and '>'.
+func tokenEnd(path string) int {
+	i := 1
+	for i < len(path) && isNameChar(path[i]) {
+		i++
+	}
+	if path[0] != ':' || i == len(path) || path[i] != '<' {
+		return i
+	}
+
+	// constraint may contain nested '<' and '>', '/' is
+	// scanned as well so that parseToken can reject it
+	depth := 0
+	for ; i < len(path); i++ {
+		switch path[i] {
+		case '<':
+			depth++
+		case '>':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(path)
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || '0' <= c && c <= '9' || c == '_'
+}
+
+// parseToken splits parameter or wildcard token into name and constraint,
+// i.e. ":id<int>" into "id" and "int".
+func parseToken(token string) (key string, expr string, err error) {
+	key = token[1:]
+	idx := strings.IndexByte(key, '<')
+	if idx < 0 {
+		return key, "", nil
+	}
+	if key[len(key)-1] != '>' {
+		return "", "", fmt.Errorf("constraint of %s must be enclosed by '<' and '>'", token)
+	}
+	expr = key[idx+1 : len(key)-1]
+	if strings.IndexByte(expr, '/') >= 0 {
+		// parameters never match across segments
+		return "", "", fmt.Errorf("constraint of %s cannot contain '/'", token)
+	}
+	return key[:idx], expr, nil
+}
+
+// expandOptional expands pattern with optional parameters into routes
+// without them, i.e. "/archive/:year?/:month?" into "/archive",
+// "/archive/:year" and "/archive/:year/:month". Optional parameters
+// must take whole segments at the end of pattern.
+func expandOptional(pattern string) ([]string, error) {
+	if strings.IndexByte(pattern, '?') < 0 {
+		return []string{pattern}, nil
+	}
+
+	invalid := func(reason string) error {
+		return &PatternError{Kind: InvalidPattern, Pattern: pattern, Reason: reason}
+	}
+
+	var routes []string
+	route := make([]byte, 0, len(pattern))
+	optional := false
+	for i := 0; i < len(pattern); {
+		switch pattern[i] {
+		case ':', '*':
+			end := i + tokenEnd(pattern[i:])
+			if end == len(pattern) || pattern[end] != '?' {
+				if optional {
+					return nil, invalid("optional parameters must be the last segments")
+				}
+				route = append(route, pattern[i:end]...)
+				i = end
+				continue
+			}
+			if pattern[i] == '*' {
+				return nil, invalid("wildcard cannot be optional")
+			}
+			if pattern[i-1] != '/' || (end+1 < len(pattern) && pattern[end+1] != '/') {
+				return nil, invalid("optional parameter must take a whole segment")
+			}
+
+			// route without this parameter and its leading '/'
+			if len(route) > 1 {
+				routes = append(routes, string(route[:len(route)-1]))
+			} else {
+				routes = append(routes, "/")
+			}
+			optional = true
+			route = append(route, pattern[i:end]...)
+			i = end + 1
+		case '?':
+			return nil, invalid("'?' must follow a parameter")
+		default:
+			if optional && pattern[i] != '/' {
+				return nil, invalid("optional parameters must be the last segments")
+			}
+			route = append(route, pattern[i])
+			i++
+		}
+	}
+	return append(routes, string(route)), nil
+}
+
+// BuildPath rebuilds path from pattern, parameter and wildcard segments
+// are filled by values and escaped. It returns error if any named
+// parameter is missing or the value does not satisfy the constraint.
+// Optional parameters can be missing, the path ends before the first
+// missing one.
+func BuildPath(pattern string, values map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		if pattern[i] != ':' && pattern[i] != '*' {
+			b.WriteByte(pattern[i])
+			i++
+			continue
+		}
+
+		end := tokenEnd(pattern[i:])
+		key, expr, err := parseToken(pattern[i : i+end])
+		if err != nil {
+			return "", err
+		}
+
+		if pattern[i] == '*' {
+			// unnamed wildcard has no value, suffix is written as static text
+			if key != "" {
+				value, ok := values[key]
+				if !ok {
+					return "", fmt.Errorf("missing wildcard parameter %s", key)
+				}
+				segments := strings.Split(value, "/")
+				for j := range segments {
+					segments[j] = url.PathEscape(segments[j])
+				}
+				b.WriteString(strings.Join(segments, "/"))
+			}
+			i += end
+			continue
+		}
+
+		value, ok := values[key]
+		optional := i+end < len(pattern) && pattern[i+end] == '?'
+		if optional && (!ok || value == "") {
+			path := strings.TrimSuffix(b.String(), "/")
+			if path == "" {
+				path = "/"
+			}
+			return path, nil
+		}
+		if !ok || value == "" {
+			return "", fmt.Errorf("missing parameter %s", key)
+		}
+		match, err := newConstraint(expr)
+		if err != nil {
+			return "", err
+		}
+		if match != nil && !match(value) {
+			return "", fmt.Errorf("parameter %s does not satisfy constraint %s: %s", key, expr, value)
+		}
+		b.WriteString(url.PathEscape(value))
+		i += end
+		if optional {
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// CheckDefault returns error if key is not an optional parameter of
+// pattern or value does not satisfy the constraint of the parameter,
+// i.e. "abc" for ":page<int>?".
+func CheckDefault(pattern string, key string, value string) error {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != ':' {
+			continue
+		}
+
+		end := i + tokenEnd(pattern[i:])
+		name, expr, err := parseToken(pattern[i:end])
+		if err != nil {
+			return err
+		}
+		if name != key || end == len(pattern) || pattern[end] != '?' {
+			i = end - 1
+			continue
+		}
+
+		match, err := newConstraint(expr)
+		if err != nil {
+			return err
+		}
+		if match != nil && !match(value) {
+			return fmt.Errorf("default value of %s does not satisfy constraint %s: %s", key, expr, value)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not an optional parameter", key)
+}
+
+// ConflictKind is the kind of PatternError.
+type ConflictKind int
+
+const (
+	InvalidPattern   ConflictKind = iota // pattern cannot be parsed, i.e. unnamed parameter
+	ShadowedPattern                      // pattern can never be matched as registered one is matched instead
+	WildcardOverride                     // wildcard would replace the registered wildcard
+	DuplicatePattern                     // pattern is registered twice for the same method
+)
+
+// String returns the name of conflict kind.
+func (k ConflictKind) String() string {
+	switch k {
+	case InvalidPattern:
+		return "invalid pattern"
+	case ShadowedPattern:
+		return "shadowed pattern"
+	case WildcardOverride:
+		return "wildcard override"
+	case DuplicatePattern:
+		return "duplicate pattern"
+	}
+	return "unknown conflict"
+}
+
+// PatternError is returned when a pattern is invalid or conflicts
+// with registered patterns.
+type PatternError struct {
+	Kind     ConflictKind
+	Pattern  string // pattern being registered
+	Existing string // registered pattern conflicted with, empty if not any
+	Reason   string
+}
+
+// Error returns the description of error.
+func (e *PatternError) Error() string {
+	if e.Existing == "" {
+		return fmt.Sprintf("%s: %s, %s", e.Pattern, e.Kind, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s, %s, conflicts with %s", e.Pattern, e.Kind, e.Reason, e.Existing)
+}
@@ -7,132 +7,604 @@ import (
 	"github.com/knchan0x/umeshu/log"
 )
 
-// RadixNode is a radix node.
+// Param is a single route parameter, consisting of a key and a value.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params is a slice of Param, the order follows the order of parameters
+// in the registered pattern.
+type Params []Param
+
+// Get returns the value of the first Param which key matches the given
+// name and a boolean reporting whether it exists.
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ByName returns the value of the first Param which key matches the given
+// name, empty string if no such Param.
+func (ps Params) ByName(name string) string {
+	value, _ := ps.Get(name)
+	return value
+}
+
+type nodeType uint8
+
+const (
+	static   nodeType = iota // static path, e.g. "/users"
+	param                    // parameter pattern, e.g. ":id"
+	catchAll                 // wildcard pattern, e.g. "*filepath"
+)
+
+// RadixNode is a node of a prefix-compressed radix tree.
+//
+// Static paths are compressed character by character, i.e. "/users"
+// and "/uploads" share a "/u" node. Parameter and wildcard patterns
+// are stored in dedicated children. They may share a path segment with
+// static text, i.e. "/files/:name.:ext", "/v:version/users" and
+// "/assets/*path.js", but two of them cannot be adjacent.
+//
+// Parameter may have a constraint, either a builtin one (int, uint,
+// alpha, alnum, uuid) or a regular expression, i.e. ":id<int>" or
+// ":slug<[a-z0-9-]+>", it is checked against the parameter value matched.
+// Parameters with different constraints can be registered in the same
+// level, they are tried one by one until a constraint is satisfied.
 type RadixNode struct {
 	// self
-	pattern string // pattern registered
-	path    string // path registered
-	isParam bool   // is parameter pattern
-	isAny   bool   // is wildcard pattern
+	path    string   // static: compressed path, param: ":name", catchAll: "*name" with suffix
+	key     string   // name of parameter or wildcard
+	nType   nodeType // type of node
+	pattern string   // pattern registered, empty if it is not the end of a pattern
+
+	// parameter constraint, i.e. "int" of ":id<int>"
+	constraint string
+	match      constraint // nil if no constraint
+
+	// static text following wildcard, i.e. ".js" of "*path.js"
+	suffix string
 
 	// child node
-	children      []*RadixNode
-	hasParamChild bool // only one param child is allowed
-	hasAnyChild   bool // "*"
+	indices       string       // first byte of each static child
+	children      []*RadixNode // static children
+	paramChildren []*RadixNode // constrained ones first, only one unconstrained is allowed
+	anyChildren   []*RadixNode // "*", longer suffix first, only one without suffix is allowed
 }
 
 // NewRootNode returns a new *RadixNode.
 func NewRootNode() *RadixNode {
-	return &RadixNode{
-		path: "/",
-	}
+	return &RadixNode{}
 }
 
-// Find searchs radix node according to parts
-func (self *RadixNode) Find(parts []string) *RadixNode {
-	return self.findChild(parts, 0)
+// Find searchs the registered node according to path, parameters matched
+// are appended to params. params can be nil if parameters are not needed.
+//
+// Children are tried in order of priority: static children first,
+// then the parameter child and finally the wildcard child. If a
+// branch dead-ends, it backtracks and tries the next candidate, so
+// the result does not depend on the order of registration.
+//
+// It walks the raw path bytes and does not allocate as long as params
+// has enough capacity.
+func (self *RadixNode) Find(path string, params *Params) *RadixNode {
+	return self.findChild(path, params)
 }
 
-func (self *RadixNode) findChild(parts []string, height int) *RadixNode {
-	if len(parts) == height || strings.HasPrefix(self.pattern, "*") {
-		return self
+// findChild searchs path below the node, path of node itself must be
+// matched already.
+func (self *RadixNode) findChild(path string, params *Params) *RadixNode {
+	if path == "" {
+		if self.pattern != "" {
+			return self
+		}
+		// wildcard also matches empty remaining path
+		return self.matchAny(path, params)
 	}
 
-	part := parts[height]
-	children := self.matchChildren(part)
-	for _, child := range children {
-		result := child.findChild(parts, height+1)
+	// static child
+	if idx := strings.IndexByte(self.indices, path[0]); idx >= 0 {
+		child := self.children[idx]
+		if strings.HasPrefix(path, child.path) {
+			if result := child.findChild(path[len(child.path):], params); result != nil {
+				return result
+			}
+		}
+	}
+
+	// parameter children match until the end of segment or static text
+	// following them, falls through to the next one if constraint fails
+	if len(self.paramChildren) > 0 {
+		end := segmentEnd(path)
+		for _, child := range self.paramChildren {
+			if result := child.matchParam(path, end, params); result != nil {
+				return result
+			}
+		}
+	}
+
+	// wildcard child consumes all remaining path
+	return self.matchAny(path, params)
+}
+
+// matchParam matches the parameter node with path, end is the end of
+// current segment. Static text following the parameter in the same
+// segment, i.e. "." of ":name.:ext", is tried first from its last
+// occurrence, then the whole segment, so "a.tar.gz" is matched with
+// name "a.tar" and ext "gz".
+func (self *RadixNode) matchParam(path string, end int, params *Params) *RadixNode {
+	if end == 0 {
+		return nil
+	}
+	if self.splitsSegment() {
+		for j := end - 1; j > 0; j-- {
+			if strings.IndexByte(self.indices, path[j]) < 0 {
+				continue
+			}
+			if result := self.matchParamAt(path, j, params); result != nil {
+				return result
+			}
+		}
+	}
+	return self.matchParamAt(path, end, params)
+}
+
+// matchParamAt matches the parameter node with path[:j] as value
+// and the rest below it.
+func (self *RadixNode) matchParamAt(path string, j int, params *Params) *RadixNode {
+	if self.match != nil && !self.match(path[:j]) {
+		return nil
+	}
+	if params != nil {
+		*params = append(*params, Param{Key: self.key, Value: path[:j]})
+	}
+	if result := self.findChild(path[j:], params); result != nil {
 		return result
 	}
+	if params != nil {
+		*params = (*params)[:len(*params)-1]
+	}
 	return nil
 }
 
-func (self *RadixNode) matchChildren(part string) []*RadixNode {
-	nodes := make([]*RadixNode, 0)
-	for _, child := range self.children {
-		if child.pattern == part || child.isParam || child.isAny || part[0] == '*' {
-			nodes = append(nodes, child)
+// splitsSegment reports whether the parameter node is followed by
+// static text in the same segment.
+func (self *RadixNode) splitsSegment() bool {
+	for i := 0; i < len(self.indices); i++ {
+		if self.indices[i] != '/' {
+			return true
 		}
 	}
-	return nodes
+	return false
 }
 
-func (self *RadixNode) matchChild(part string) *RadixNode {
-	for _, child := range self.children {
-		if child.pattern == part {
-			return child
+// matchAny returns the wildcard child which suffix matches path and
+// stores the remaining path without suffix as parameter value.
+func (self *RadixNode) matchAny(path string, params *Params) *RadixNode {
+	for _, child := range self.anyChildren {
+		if !strings.HasSuffix(path, child.suffix) {
+			continue
+		}
+		if params != nil && child.key != "" {
+			*params = append(*params, Param{Key: child.key, Value: path[:len(path)-len(child.suffix)]})
 		}
+		return child
 	}
 	return nil
 }
 
-// Insert adds new node to radix tree.
-func (self *RadixNode) Insert(parts []string) {
-	self.insertChild(parts, 0)
+// FindCaseInsensitive searchs the registered node according to path
+// case-insensitively and returns the path with the case of static parts
+// fixed to the registered one, parameter values are kept as they are.
+//
+// It follows the same priority as Find.
+func (self *RadixNode) FindCaseInsensitive(path string) (string, bool) {
+	buf := make([]byte, 0, len(path))
+	if fixed, found := self.findChildCaseInsensitive(path, buf); found {
+		return string(fixed), true
+	}
+	return "", false
 }
 
-func (self *RadixNode) insertChild(parts []string, height int) {
-	if len(parts) == height {
-		return
+// findChildCaseInsensitive searchs path below the node case-insensitively
+// and appends the fixed path to buf.
+func (self *RadixNode) findChildCaseInsensitive(path string, buf []byte) ([]byte, bool) {
+	if path == "" && self.pattern != "" {
+		return buf, true
 	}
 
-	part := parts[height]
-	child := self.matchChild(part)
-	if child == nil {
-		path := "/" + strings.Join(parts[:height+1], "/")
+	// static children, more than one child may match in different case
+	for _, child := range self.children {
+		if len(path) >= len(child.path) && strings.EqualFold(path[:len(child.path)], child.path) {
+			if fixed, found := child.findChildCaseInsensitive(path[len(child.path):], append(buf, child.path...)); found {
+				return fixed, true
+			}
+		}
+	}
 
-		// unable to add new pattern if wildcard pattern exists
-		if part[0] != '*' && self.hasAnyChild {
-			log.Panic("fail to add %s, duplicated with existing wildcard pattern", path)
-			return
+	// parameter children, static text following the parameter in the
+	// same segment is tried before the whole segment as Find does
+	end := segmentEnd(path)
+	for _, child := range self.paramChildren {
+		if end == 0 {
+			break
+		}
+		for j := end - 1; j > 0 && child.splitsSegment(); j-- {
+			if fixed, found := child.matchParamCaseInsensitive(path, j, buf); found {
+				return fixed, true
+			}
 		}
-		// only one parameter pattern is allowed in the same level
-		if part[0] == ':' && self.hasParamChild {
-			log.Panic("fail to add %s, dynamic patterns already exists", path)
+		if fixed, found := child.matchParamCaseInsensitive(path, end, buf); found {
+			return fixed, true
 		}
+	}
 
-		child = &RadixNode{
-			path:    path,
-			pattern: part,
-			isParam: part[0] == ':',
-			isAny:   part[0] == '*',
-		}
-
-		if child.isParam || child.isAny {
-			if child.isParam {
-				self.children = append(self.children, child)
-				self.hasParamChild = true
-			}
-			if child.isAny {
-				if len(self.children) != 0 {
-					if self.hasAnyChild {
-						log.Warning("adding %s will replace %s", path, self.children[0].path)
-						self.children[0] = child
-					} else {
-						log.Warning("%s will replace all child nodes of %s", path, "/"+strings.Join(parts[:height], "/"))
-						self.children = []*RadixNode{child}
+	// wildcard children
+	for _, child := range self.anyChildren {
+		n := len(path) - len(child.suffix)
+		if n >= 0 && strings.EqualFold(path[n:], child.suffix) {
+			return append(append(buf, path[:n]...), child.suffix...), true
+		}
+	}
+	return nil, false
+}
+
+// matchParamCaseInsensitive matches the parameter node with path[:j]
+// as value and the rest below it case-insensitively.
+func (self *RadixNode) matchParamCaseInsensitive(path string, j int, buf []byte) ([]byte, bool) {
+	if self.match != nil && !self.match(path[:j]) {
+		return nil, false
+	}
+	return self.findChildCaseInsensitive(path[j:], append(buf, path[:j]...))
+}
+
+// Insert adds new pattern to radix tree. It returns *PatternError if
+// pattern is invalid or conflicts with registered patterns, i.e.
+// "/users/:name" with "/users/:id", pattern is not added in that case.
+//
+// Optional parameters, i.e. "/archive/:year?/:month?", are expanded into
+// "/archive", "/archive/:year" and "/archive/:year/:month", all of them
+// are marked by the pattern, so Find returns the pattern for each of them.
+//
+// The node itself is modified, but nodes below it are copied before
+// they are modified. Together with Clone, i.e. root.Clone().Insert(pattern),
+// it creates a new tree without modifying the original tree, so that
+// the original tree can be searched concurrently. Discard the copy if
+// error is returned, as nodes may be split before the error is found.
+func (self *RadixNode) Insert(pattern string) error {
+	routes, err := expandOptional(pattern)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if err := self.insertChild(route, 0, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertChild adds route[i:] below the node, path of node itself must
+// be matched already. route is pattern or one of its expansions without
+// optional parameters, the end node is marked by pattern.
+func (self *RadixNode) insertChild(route string, i int, pattern string) error {
+	if i == len(route) {
+		if self.pattern != "" && self.pattern != pattern {
+			return &PatternError{
+				Kind:     ShadowedPattern,
+				Pattern:  pattern,
+				Existing: self.pattern,
+				Reason:   fmt.Sprintf("%s is registered already", route),
+			}
+		}
+		self.pattern = pattern
+		return nil
+	}
+
+	path := route[i:]
+	if (path[0] == ':' || path[0] == '*') && (self.nType == param || self.nType == catchAll) {
+		return &PatternError{
+			Kind:    InvalidPattern,
+			Pattern: pattern,
+			Reason:  "parameters must be separated by static text",
+		}
+	}
+
+	switch path[0] {
+	case ':':
+		end := tokenEnd(path)
+		key, expr, err := parseToken(path[:end])
+		if err != nil {
+			return &PatternError{Kind: InvalidPattern, Pattern: pattern, Reason: err.Error()}
+		}
+		if key == "" {
+			return &PatternError{Kind: InvalidPattern, Pattern: pattern, Reason: "parameter must be named"}
+		}
+
+		// only one parameter pattern is allowed for each constraint in the same level
+		var child *RadixNode
+		for j, c := range self.paramChildren {
+			if c.constraint == expr {
+				if c.key != key {
+					return &PatternError{
+						Kind:     ShadowedPattern,
+						Pattern:  pattern,
+						Existing: c.anyPattern(),
+						Reason:   fmt.Sprintf("parameter %s is registered as %s", path[:end], c.path),
 					}
-				} else {
-					self.children = append(self.children, child)
 				}
+				child = c.Clone()
+				self.paramChildren[j] = child
+				break
+			}
+		}
+
+		if child == nil {
+			match, err := newConstraint(expr)
+			if err != nil {
+				return &PatternError{
+					Kind:    InvalidPattern,
+					Pattern: pattern,
+					Reason:  fmt.Sprintf("invalid constraint %s: %s", expr, err),
+				}
+			}
+			child = &RadixNode{
+				path:       path[:end],
+				key:        key,
+				nType:      param,
+				constraint: expr,
+				match:      match,
+			}
+			self.addParamChild(child)
+		}
+		return child.insertChild(route, i+end, pattern)
 
-				self.hasAnyChild = true
-				if len(part) == 1 {
-					log.Warning("%s is unnamed wildcard", child.path)
+	case '*':
+		// only one "*" is allowed, it can only be followed by
+		// static text in the last segment, i.e. "*path.js"
+		end := tokenEnd(path)
+		suffix := path[end:]
+		if strings.ContainsAny(suffix, "/:*") {
+			return &PatternError{
+				Kind:    InvalidPattern,
+				Pattern: pattern,
+				Reason:  "wildcard must be the last segment and followed by static text only",
+			}
+		}
+		for _, c := range self.anyChildren {
+			if c.suffix != suffix {
+				continue
+			}
+			if c.pattern != pattern {
+				return &PatternError{
+					Kind:     WildcardOverride,
+					Pattern:  pattern,
+					Existing: c.pattern,
+					Reason:   "only one wildcard is allowed for each suffix in the same level",
 				}
 			}
+			return nil
+		}
+		if end == 1 {
+			log.Warning("%s is unnamed wildcard", pattern)
+		}
+
+		self.addAnyChild(&RadixNode{
+			path:    path,
+			key:     path[1:end],
+			nType:   catchAll,
+			pattern: pattern,
+			suffix:  suffix,
+		})
+		return nil
+
+	default:
+		end := staticEnd(route, i)
+		chunk := route[i:end]
+
+		idx := strings.IndexByte(self.indices, chunk[0])
+		if idx < 0 {
+			child := &RadixNode{path: chunk}
+			self.indices += string(chunk[0])
+			self.children = append(self.children, child)
+			return child.insertChild(route, end, pattern)
+		}
+
+		// split the existing child if only part of it is shared
+		child := self.children[idx].Clone()
+		self.children[idx] = child
+		l := longestCommonPrefix(child.path, chunk)
+		if l < len(child.path) {
+			split := &RadixNode{
+				path:     child.path[:l],
+				indices:  string(child.path[l]),
+				children: []*RadixNode{child},
+			}
+			child.path = child.path[l:]
+			self.children[idx] = split
+			child = split
+		}
+		return child.insertChild(route, i+l, pattern)
+	}
+}
+
+// Remove removes pattern from radix tree, it reports whether pattern
+// exists. Like Insert, nodes below the node are copied before they
+// are modified.
+func (self *RadixNode) Remove(pattern string) bool {
+	routes, err := expandOptional(pattern)
+	if err != nil {
+		return false
+	}
+	removed := false
+	for _, route := range routes {
+		removed = self.removeChild(route, 0, pattern) || removed
+	}
+	return removed
+}
+
+// removeChild removes route[i:] below the node, path of node itself
+// must be matched already. route is pattern or one of its expansions.
+func (self *RadixNode) removeChild(route string, i int, pattern string) bool {
+	if i == len(route) {
+		if self.pattern != pattern {
+			return false
+		}
+		self.pattern = ""
+		return true
+	}
+
+	path := route[i:]
+
+	switch path[0] {
+	case ':':
+		end := tokenEnd(path)
+		for j, c := range self.paramChildren {
+			if c.path != path[:end] {
+				continue
+			}
+			child := c.Clone()
+			if !child.removeChild(route, i+end, pattern) {
+				return false
+			}
+			if child.isEmpty() {
+				self.paramChildren = append(self.paramChildren[:j], self.paramChildren[j+1:]...)
+			} else {
+				self.paramChildren[j] = child
+			}
+			return true
+		}
+		return false
+
+	case '*':
+		for j, c := range self.anyChildren {
+			if c.pattern == pattern {
+				self.anyChildren = append(self.anyChildren[:j], self.anyChildren[j+1:]...)
+				return true
+			}
+		}
+		return false
+
+	default:
+		idx := strings.IndexByte(self.indices, path[0])
+		if idx < 0 || !strings.HasPrefix(path, self.children[idx].path) {
+			return false
+		}
+		child := self.children[idx].Clone()
+		if !child.removeChild(route, i+len(child.path), pattern) {
+			return false
+		}
+		if child.isEmpty() {
+			self.indices = self.indices[:idx] + self.indices[idx+1:]
+			self.children = append(self.children[:idx], self.children[idx+1:]...)
 		} else {
-			self.children = append([]*RadixNode{child}, self.children...)
+			self.children[idx] = child
+		}
+		return true
+	}
+}
+
+// isEmpty reports whether the node is neither the end of a pattern
+// nor has any child.
+func (self *RadixNode) isEmpty() bool {
+	return self.pattern == "" && len(self.children) == 0 && len(self.paramChildren) == 0 && len(self.anyChildren) == 0
+}
+
+// Clone returns a copy of the node, children are shared with the
+// original node.
+func (self *RadixNode) Clone() *RadixNode {
+	clone := *self
+	clone.children = append([]*RadixNode(nil), self.children...)
+	clone.paramChildren = append([]*RadixNode(nil), self.paramChildren...)
+	clone.anyChildren = append([]*RadixNode(nil), self.anyChildren...)
+	return &clone
+}
+
+// anyPattern returns a pattern registered at or below the node,
+// empty string if not any.
+func (self *RadixNode) anyPattern() string {
+	if self.pattern != "" {
+		return self.pattern
+	}
+	for _, child := range self.children {
+		if pattern := child.anyPattern(); pattern != "" {
+			return pattern
+		}
+	}
+	for _, child := range self.paramChildren {
+		if pattern := child.anyPattern(); pattern != "" {
+			return pattern
+		}
+	}
+	if len(self.anyChildren) > 0 {
+		return self.anyChildren[0].pattern
+	}
+	return ""
+}
+
+// addParamChild adds parameter child, constrained children are placed
+// before the unconstrained one so that they are tried first.
+func (self *RadixNode) addParamChild(child *RadixNode) {
+	n := len(self.paramChildren)
+	if child.match == nil || n == 0 || self.paramChildren[n-1].match != nil {
+		self.paramChildren = append(self.paramChildren, child)
+		return
+	}
+	self.paramChildren = append(self.paramChildren[:n-1], child, self.paramChildren[n-1])
+}
+
+// addAnyChild adds wildcard child, children with longer suffix are
+// placed before so that they are tried first.
+func (self *RadixNode) addAnyChild(child *RadixNode) {
+	idx := len(self.anyChildren)
+	for j, c := range self.anyChildren {
+		if len(c.suffix) < len(child.suffix) {
+			idx = j
+			break
 		}
 	}
+	self.anyChildren = append(self.anyChildren, nil)
+	copy(self.anyChildren[idx+1:], self.anyChildren[idx:])
+	self.anyChildren[idx] = child
+}
+
+// segmentEnd returns the index of the first '/' in path,
+// length of path if not found.
+func segmentEnd(path string) int {
+	if end := strings.IndexByte(path, '/'); end >= 0 {
+		return end
+	}
+	return len(path)
+}
 
-	child.insertChild(parts, height+1)
+// staticEnd returns the index where the static path starting from
+// pattern[i] ends, i.e. the beginning of next parameter or wildcard
+// or the end of pattern.
+func staticEnd(pattern string, i int) int {
+	if j := strings.IndexAny(pattern[i+1:], ":*"); j >= 0 {
+		return i + 1 + j
+	}
+	return len(pattern)
+}
+
+// longestCommonPrefix returns the length of common prefix of a and b.
+func longestCommonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
 }
 
 // String returns formatted string of a node's data.
 func (self *RadixNode) String() string {
-	return fmt.Sprintf("pattern: %s, path: %s, isParam: %t, isAny: %t, no of children: %d, hasParamChild: %t, hasAnyChild: %t",
-		self.pattern, self.path, self.isParam, self.isAny, len(self.children), self.hasParamChild, self.hasAnyChild)
+	return fmt.Sprintf("path: %s, pattern: %s, type: %d, indices: %s, no of children: %d, no of param children: %d, hasAnyChild: %t",
+		self.path, self.pattern, self.nType, self.indices, len(self.children), len(self.paramChildren), len(self.anyChildren) > 0)
 }
 
 // Travel returns a slice contains all nodes.
@@ -141,9 +613,16 @@ func (self *RadixNode) Travel(list *([]*RadixNode)) {
 	for _, child := range self.children {
 		child.Travel(list)
 	}
+	for _, child := range self.paramChildren {
+		child.Travel(list)
+	}
+	for _, child := range self.anyChildren {
+		child.Travel(list)
+	}
 }
 
-// GetPath returns the path value.
-func (self *RadixNode) GetPath() string {
-	return self.path
+// GetPattern returns the pattern registered, empty string if the node
+// is not the end of a pattern.
+func (self *RadixNode) GetPattern() string {
+	return self.pattern
 }
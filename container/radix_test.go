@@ -2,33 +2,15 @@ package container
 
 import (
 	"fmt"
-	"strings"
+	"reflect"
 	"testing"
 )
 
-func parsePattern(pattern string) []string {
-	s := strings.Split(pattern, "/")
-
-	parts := make([]string, 0)
-	for _, part := range s {
-		if part != "" {
-			parts = append(parts, part)
-			if part[0] == '*' {
-				break
-			}
-		}
-	}
-	return parts
-}
-
 func insertNodes(paths []string) *RadixNode {
-	root_GET := &RadixNode{
-		path: "/",
-	}
+	root_GET := NewRootNode()
 
 	for _, path := range paths {
-		parts := parsePattern(path)
-		root_GET.Insert(parts)
+		root_GET.Insert(path)
 	}
 
 	return root_GET
@@ -99,13 +81,467 @@ func TestFind(t *testing.T) {
 	}
 
 	for idx, path := range find {
-		parts := parsePattern(path)
-		node := root_GET.Find(parts)
+		node := root_GET.Find(path, nil)
 		if node == nil {
 			t.Fatal("shouldn't return nil")
 		}
-		if node.path != ans[idx] {
+		if node.pattern != ans[idx] {
 			t.Fatal("incorrect matching")
 		}
 	}
 }
+
+func TestFindPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		path   string
+		want   string // empty if no route should match
+	}{
+		{"static before param", []string{"/users/new", "/users/:id/edit"}, "/users/new", "/users/new"},
+		{"backtrack from static to param", []string{"/users/new", "/users/:id/edit"}, "/users/new/edit", "/users/:id/edit"},
+		{"param", []string{"/users/new", "/users/:id/edit"}, "/users/42/edit", "/users/:id/edit"},
+		{"param without end", []string{"/users/new", "/users/:id/edit"}, "/users/42", ""},
+		{"static before wildcard", []string{"/files/*path", "/files/:name/info", "/files/readme"}, "/files/readme", "/files/readme"},
+		{"param before wildcard", []string{"/files/*path", "/files/:name/info", "/files/readme"}, "/files/a/info", "/files/:name/info"},
+		{"backtrack from static to param before wildcard", []string{"/files/*path", "/files/:name/info", "/files/readme"}, "/files/readme/info", "/files/:name/info"},
+		{"backtrack to wildcard", []string{"/files/*path", "/files/:name/info", "/files/readme"}, "/files/readme/raw", "/files/*path"},
+		{"backtrack from param to wildcard", []string{"/files/*path", "/files/:name/info", "/files/readme"}, "/files/a/b/c", "/files/*path"},
+		{"wildcard matches empty", []string{"/files/*path", "/files/readme"}, "/files/", "/files/*path"},
+		{"shared prefix", []string{"/user", "/users", "/uploads/:id"}, "/users", "/users"},
+		{"shared prefix param", []string{"/user", "/users", "/uploads/:id"}, "/uploads/1", "/uploads/:id"},
+		{"shared prefix no match", []string{"/user", "/users", "/uploads/:id"}, "/use", ""},
+		{"static before param inside segment", []string{"/v1/users", "/v:version/users"}, "/v1/users", "/v1/users"},
+		{"param inside segment", []string{"/v1/users", "/v:version/users"}, "/v2/users", "/v:version/users"},
+		{"static text after param before whole segment", []string{"/files/:name", "/files/:name.:ext"}, "/files/a.b", "/files/:name.:ext"},
+		{"whole segment without static text", []string{"/files/:name", "/files/:name.:ext"}, "/files/a", "/files/:name"},
+		{"wildcard with suffix", []string{"/assets/*path", "/assets/*path.js"}, "/assets/a/b.js", "/assets/*path.js"},
+		{"wildcard without suffix", []string{"/assets/*path", "/assets/*path.js"}, "/assets/a/b.css", "/assets/*path"},
+		{"root", []string{"/", "/:user", "/admin", "/*any"}, "/", "/"},
+		{"root static", []string{"/", "/:user", "/admin", "/*any"}, "/admin", "/admin"},
+		{"root param", []string{"/", "/:user", "/admin", "/*any"}, "/bob", "/:user"},
+		{"root wildcard", []string{"/", "/:user", "/admin", "/*any"}, "/bob/profile", "/*any"},
+		{"intermediate node is not a route", []string{"/view/:id/:user"}, "/view/1", ""},
+		{"no match", []string{"/a/b", "/a/:c/d"}, "/a/b/e", ""},
+	}
+
+	for _, tt := range tests {
+		// matching must not depend on the order of registration
+		for _, routes := range [][]string{tt.routes, reverse(tt.routes)} {
+			root := insertNodes(routes)
+			got := ""
+			if node := root.Find(tt.path, nil); node != nil {
+				got = node.pattern
+			}
+			if got != tt.want {
+				t.Errorf("%s: routes %v, find %s = %q, want %q", tt.name, routes, tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFindParams(t *testing.T) {
+	routes := []string{
+		"/users/new",
+		"/users/:id",
+		"/users/:id/posts/:post",
+		"/static/*filepath",
+		"/raw/*",
+	}
+	root := insertNodes(routes)
+
+	tests := []struct {
+		path string
+		want Params
+	}{
+		{"/users/new", Params{}},
+		{"/users/42", Params{{"id", "42"}}},
+		{"/users/42/posts/7", Params{{"id", "42"}, {"post", "7"}}},
+		{"/users/new/posts/7", Params{{"id", "new"}, {"post", "7"}}},
+		{"/static/css/style.css", Params{{"filepath", "css/style.css"}}},
+		{"/static/", Params{{"filepath", ""}}},
+		{"/raw/a/b", Params{}},
+	}
+
+	for _, tt := range tests {
+		params := make(Params, 0, 4)
+		if node := root.Find(tt.path, &params); node == nil {
+			t.Fatalf("find %s shouldn't return nil", tt.path)
+		}
+		if !reflect.DeepEqual(params, tt.want) {
+			t.Errorf("find %s, params = %v, want %v", tt.path, params, tt.want)
+		}
+	}
+
+	// params must be restored when a branch dead-ends
+	params := make(Params, 0, 4)
+	if node := root.Find("/users/42/posts", &params); node != nil {
+		t.Fatalf("find /users/42/posts should return nil, got %s", node.pattern)
+	}
+	if len(params) != 0 {
+		t.Errorf("params should be empty, got %v", params)
+	}
+}
+
+func BenchmarkFind_static(b *testing.B) {
+	root := insertNodes([]string{"/", "/users/new", "/users/:id", "/users/:id/posts/:post", "/static/*filepath"})
+	params := make(Params, 0, 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params = params[:0]
+		root.Find("/users/new", &params)
+	}
+}
+
+func BenchmarkFind_param(b *testing.B) {
+	root := insertNodes([]string{"/", "/users/new", "/users/:id", "/users/:id/posts/:post", "/static/*filepath"})
+	params := make(Params, 0, 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params = params[:0]
+		root.Find("/users/42/posts/7", &params)
+	}
+}
+
+func TestFindMixedParams(t *testing.T) {
+	routes := []string{
+		"/files/:name.:ext",
+		"/v:version/users",
+		"/v:major<int>.:minor<int>/docs",
+		"/date/:year-:month<int>",
+		"/assets/*path.min.js",
+		"/assets/*path.js",
+	}
+	root := insertNodes(routes)
+
+	tests := []struct {
+		path    string
+		pattern string
+		want    Params
+	}{
+		{"/files/report.pdf", "/files/:name.:ext", Params{{"name", "report"}, {"ext", "pdf"}}},
+		{"/files/a.tar.gz", "/files/:name.:ext", Params{{"name", "a.tar"}, {"ext", "gz"}}},
+		{"/v2/users", "/v:version/users", Params{{"version", "2"}}},
+		{"/v1.2/docs", "/v:major<int>.:minor<int>/docs", Params{{"major", "1"}, {"minor", "2"}}},
+		{"/date/2021-10", "/date/:year-:month<int>", Params{{"year", "2021"}, {"month", "10"}}},
+		{"/assets/js/app.min.js", "/assets/*path.min.js", Params{{"path", "js/app"}}},
+		{"/assets/js/app.js", "/assets/*path.js", Params{{"path", "js/app"}}},
+	}
+
+	for _, tt := range tests {
+		params := make(Params, 0, 4)
+		node := root.Find(tt.path, &params)
+		if node == nil || node.pattern != tt.pattern {
+			t.Errorf("find %s = %v, want %s", tt.path, node, tt.pattern)
+			continue
+		}
+		if !reflect.DeepEqual(params, tt.want) {
+			t.Errorf("find %s, params = %v, want %v", tt.path, params, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/files/report", "/v/users", "/v1.x/docs", "/date/2021-x", "/assets/app.css"} {
+		if node := root.Find(path, nil); node != nil {
+			t.Errorf("find %s = %s, want nil", path, node.pattern)
+		}
+	}
+}
+
+func TestFindOptional(t *testing.T) {
+	root := insertNodes([]string{
+		"/posts/:page?",
+		"/posts/new",
+		"/archive/:year<int>?/:month<int>?",
+	})
+
+	tests := []struct {
+		path    string
+		pattern string
+		want    Params
+	}{
+		{"/posts", "/posts/:page?", Params{}},
+		{"/posts/2", "/posts/:page?", Params{{"page", "2"}}},
+		{"/posts/new", "/posts/new", Params{}},
+		{"/archive", "/archive/:year<int>?/:month<int>?", Params{}},
+		{"/archive/2021", "/archive/:year<int>?/:month<int>?", Params{{"year", "2021"}}},
+		{"/archive/2021/10", "/archive/:year<int>?/:month<int>?", Params{{"year", "2021"}, {"month", "10"}}},
+	}
+
+	for _, tt := range tests {
+		params := make(Params, 0, 4)
+		node := root.Find(tt.path, &params)
+		if node == nil || node.pattern != tt.pattern {
+			t.Errorf("find %s = %v, want %s", tt.path, node, tt.pattern)
+			continue
+		}
+		if !reflect.DeepEqual(params, tt.want) {
+			t.Errorf("find %s, params = %v, want %v", tt.path, params, tt.want)
+		}
+	}
+
+	if node := root.Find("/archive/x", nil); node != nil {
+		t.Errorf("find /archive/x = %s, want nil", node.pattern)
+	}
+
+	// all expanded routes are removed
+	if !root.Remove("/posts/:page?") {
+		t.Fatal("remove /posts/:page? = false, want true")
+	}
+	for _, path := range []string{"/posts", "/posts/2"} {
+		if node := root.Find(path, nil); node != nil {
+			t.Errorf("find %s after removal = %s, want nil", path, node.pattern)
+		}
+	}
+}
+
+func TestFindCaseInsensitive(t *testing.T) {
+	root := insertNodes([]string{
+		"/users",
+		"/users/:id/Profile",
+		"/USERS/admin",
+		"/static/*filepath",
+		"/files/:name.PDF",
+	})
+
+	tests := []struct {
+		path  string
+		fixed string
+		found bool
+	}{
+		{"/Files/Report.pdf", "/files/Report.PDF", true},
+		{"/users", "/users", true},
+		{"/Users", "/users", true},
+		{"/USERS/John/profile", "/users/John/Profile", true},
+		{"/users/admin", "/USERS/admin", true},
+		{"/Static/CSS/Style.css", "/static/CSS/Style.css", true},
+		{"/posts", "", false},
+		{"/users/john", "", false},
+	}
+
+	for _, tt := range tests {
+		fixed, found := root.FindCaseInsensitive(tt.path)
+		if fixed != tt.fixed || found != tt.found {
+			t.Errorf("find %s case-insensitively = (%q, %t), want (%q, %t)", tt.path, fixed, found, tt.fixed, tt.found)
+		}
+	}
+}
+
+func TestFindConstraints(t *testing.T) {
+	routes := []string{
+		"/users/:id<int>",
+		"/users/:uuid<uuid>",
+		"/users/:name",
+		"/users/:id<int>/posts",
+		"/posts/:slug<[a-z0-9-]+>",
+		"/posts/:year<^\\d{4}$>/archive",
+		"/codes/:code<alpha>",
+		"/codes/:num<uint>/x",
+	}
+
+	tests := []struct {
+		path   string
+		want   string
+		params Params
+	}{
+		{"/users/42", "/users/:id<int>", Params{{"id", "42"}}},
+		{"/users/-42", "/users/:id<int>", Params{{"id", "-42"}}},
+		{"/users/9b2c4a8e-1f3d-4c5b-8a6e-7d9f0e1a2b3c", "/users/:uuid<uuid>", Params{{"uuid", "9b2c4a8e-1f3d-4c5b-8a6e-7d9f0e1a2b3c"}}},
+		{"/users/abc", "/users/:name", Params{{"name", "abc"}}},
+		{"/users/42/posts", "/users/:id<int>/posts", Params{{"id", "42"}}},
+		{"/users/abc/posts", "", nil},
+		{"/posts/hello-world-2", "/posts/:slug<[a-z0-9-]+>", Params{{"slug", "hello-world-2"}}},
+		{"/posts/Hello", "", nil},
+		{"/posts/2021/archive", "/posts/:year<^\\d{4}$>/archive", Params{{"year", "2021"}}},
+		{"/posts/21/archive", "", nil},
+		{"/codes/abc", "/codes/:code<alpha>", Params{{"code", "abc"}}},
+		{"/codes/123/x", "/codes/:num<uint>/x", Params{{"num", "123"}}},
+		{"/codes/123", "", nil},
+	}
+
+	for _, routes := range [][]string{routes, reverse(routes)} {
+		root := insertNodes(routes)
+		for _, tt := range tests {
+			params := make(Params, 0, 4)
+			got := ""
+			if node := root.Find(tt.path, &params); node != nil {
+				got = node.pattern
+			} else {
+				params = nil
+			}
+			if got != tt.want {
+				t.Errorf("find %s = %q, want %q", tt.path, got, tt.want)
+			}
+			if tt.want != "" && !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("find %s, params = %v, want %v", tt.path, params, tt.params)
+			}
+		}
+	}
+}
+
+func TestInsertConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		kind   ConflictKind // kind of error inserting the last route
+		err    bool
+	}{
+		{"different constraints", []string{"/users/:id<int>", "/users/:name<alpha>", "/users/:any"}, 0, false},
+		{"same parameter", []string{"/users/:id<int>", "/users/:id<int>/posts"}, 0, false},
+		{"same wildcard", []string{"/files/*path", "/files/*path"}, 0, false},
+		{"same constraint with different names", []string{"/users/:id<int>", "/users/:num<int>"}, ShadowedPattern, true},
+		{"unconstrained with different names", []string{"/users/:id/posts", "/users/:name"}, ShadowedPattern, true},
+		{"wildcard with different names", []string{"/files/*path", "/files/*name"}, WildcardOverride, true},
+		{"segments after wildcard", []string{"/files/*path/raw"}, InvalidPattern, true},
+		{"parameter after wildcard", []string{"/files/*path.:ext"}, InvalidPattern, true},
+		{"adjacent parameters", []string{"/users/:id:name"}, InvalidPattern, true},
+		{"wildcards with different suffixes", []string{"/assets/*path.js", "/assets/*path.css"}, 0, false},
+		{"optional parameter", []string{"/posts/new", "/posts/:page?"}, 0, false},
+		{"optional parameter shadowing route", []string{"/posts", "/posts/:page?"}, ShadowedPattern, true},
+		{"optional parameter in the middle", []string{"/posts/:page?/edit"}, InvalidPattern, true},
+		{"optional parameter inside segment", []string{"/posts/p:page?"}, InvalidPattern, true},
+		{"optional wildcard", []string{"/files/*path?"}, InvalidPattern, true},
+		{"question mark without parameter", []string{"/posts?"}, InvalidPattern, true},
+		{"unnamed parameter", []string{"/users/:"}, InvalidPattern, true},
+		{"invalid regular expression", []string{"/users/:id<[a-z>"}, InvalidPattern, true},
+		{"unclosed constraint", []string{"/users/:id<int"}, InvalidPattern, true},
+		{"slash in constraint", []string{"/posts/:slug<[a-z/]+>"}, InvalidPattern, true},
+	}
+
+	for _, tt := range tests {
+		root := insertNodes(tt.routes[:len(tt.routes)-1])
+		last := tt.routes[len(tt.routes)-1]
+		err := root.Insert(last)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if perr, ok := err.(*PatternError); !ok || perr.Kind != tt.kind {
+			t.Errorf("%s: error = %#v, want kind %s", tt.name, err, tt.kind)
+		}
+		if node := root.Find(last, nil); node != nil && node.GetPattern() == last {
+			t.Errorf("%s: %s is inserted, want not inserted", tt.name, last)
+		}
+	}
+}
+
+func TestBuildPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		values  map[string]string
+		want    string
+		err     bool
+	}{
+		{"/users/:id<int>/posts", map[string]string{"id": "42"}, "/users/42/posts", false},
+		{"/users/:id<int>", map[string]string{"id": "abc"}, "", true},
+		{"/posts/:slug<[a-z/]+>", map[string]string{"slug": "a/b"}, "", true},
+		{"/users/:id", map[string]string{}, "", true},
+		{"/assets/*filepath", map[string]string{"filepath": "css/a b.css"}, "/assets/css/a%20b.css", false},
+		{"/files/:name.:ext", map[string]string{"name": "report", "ext": "pdf"}, "/files/report.pdf", false},
+		{"/v:version/users", map[string]string{"version": "2"}, "/v2/users", false},
+		{"/assets/*path.js", map[string]string{"path": "js/app"}, "/assets/js/app.js", false},
+		{"/archive/:year?/:month?", map[string]string{"year": "2021", "month": "10"}, "/archive/2021/10", false},
+		{"/archive/:year?/:month?", map[string]string{"year": "2021"}, "/archive/2021", false},
+		{"/archive/:year?/:month?", map[string]string{"month": "10"}, "/archive", false},
+		{"/:page?", map[string]string{}, "/", false},
+	}
+
+	for _, tt := range tests {
+		got, err := BuildPath(tt.pattern, tt.values)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("BuildPath(%s, %v) = (%q, %v), want (%q, error %t)", tt.pattern, tt.values, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCheckDefault(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		value   string
+		err     bool
+	}{
+		{"/posts/:page?", "page", "1", false},
+		{"/posts/:page<int>?", "page", "1", false},
+		{"/posts/:page<int>?", "page", "abc", true},
+		{"/archive/:year<int>/:month<int>?", "month", "10", false},
+		{"/archive/:year<int>/:month<int>?", "year", "2021", true},
+		{"/posts/:page?", "nope", "x", true},
+		{"/files/*path", "path", "a", true},
+	}
+
+	for _, tt := range tests {
+		if err := CheckDefault(tt.pattern, tt.key, tt.value); (err != nil) != tt.err {
+			t.Errorf("CheckDefault(%s, %s, %s) = %v, want error %t", tt.pattern, tt.key, tt.value, err, tt.err)
+		}
+	}
+}
+
+func reverse(routes []string) []string {
+	reversed := make([]string, len(routes))
+	for i, route := range routes {
+		reversed[len(routes)-1-i] = route
+	}
+	return reversed
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	routes := []string{"/users", "/users/:id", "/users/:id<int>/posts", "/uploads/*filepath"}
+	old := insertNodes(routes)
+
+	// insert into a copy
+	inserted := old.Clone()
+	inserted.Insert("/user")
+	inserted.Insert("/users/:id/profile")
+	inserted.Insert("/users/:id<int>/posts/:post")
+
+	// remove from a copy
+	removed := old.Clone()
+	if !removed.Remove("/users/:id<int>/posts") || !removed.Remove("/uploads/*filepath") || !removed.Remove("/users") {
+		t.Fatal("remove registered pattern should return true")
+	}
+	if removed.Remove("/users/:id/posts") || removed.Remove("/unknown") || removed.Remove("/users/:id<int>/posts") {
+		t.Fatal("remove unregistered pattern should return false")
+	}
+
+	tests := []struct {
+		path     string
+		old      string
+		inserted string
+		removed  string
+	}{
+		{"/user", "", "/user", ""},
+		{"/users", "/users", "/users", ""},
+		{"/users/42", "/users/:id", "/users/:id", "/users/:id"},
+		{"/users/42/profile", "", "/users/:id/profile", ""},
+		{"/users/42/posts", "/users/:id<int>/posts", "/users/:id<int>/posts", ""},
+		{"/users/42/posts/7", "", "/users/:id<int>/posts/:post", ""},
+		{"/uploads/a.png", "/uploads/*filepath", "/uploads/*filepath", ""},
+	}
+
+	for _, tt := range tests {
+		for _, tree := range []struct {
+			name string
+			root *RadixNode
+			want string
+		}{{"old", old, tt.old}, {"inserted", inserted, tt.inserted}, {"removed", removed, tt.removed}} {
+			got := ""
+			if node := tree.root.Find(tt.path, nil); node != nil {
+				got = node.pattern
+			}
+			if got != tree.want {
+				t.Errorf("%s tree: find %s = %q, want %q", tree.name, tt.path, got, tree.want)
+			}
+		}
+	}
+
+	// empty nodes are pruned
+	removed.Remove("/users/:id")
+	if len(removed.children) != 0 {
+		t.Errorf("all nodes should be pruned, got %d children", len(removed.children))
+	}
+}
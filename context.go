@@ -1,10 +1,18 @@
 package umeshu
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"regexp"
 	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/knchan0x/umeshu/log"
 	"github.com/knchan0x/umeshu/session"
@@ -15,34 +23,51 @@ import (
 // path, route parameters, session and registered handlers. It also holds
 // request and response objects.
 type Context struct {
+	// Writer records status code and size of the response, ResponseWriter
+	// is the same one unless it is replaced, i.e. by middleware
+	Writer         ResponseWriter
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
+	writer         responseWriter
 
 	// middlewares and handlers
 	handlers []HandlerFunc
 	index    int
 	session  session.Session
+	engine   *Engine
 
 	// provide direct access info extracts from request for convenience
 	Path        string
 	Method      string
-	RouteParams map[string]string
+	RouteParams Params
 
-	StatusCode int // status code for response
+	// status code for response set by SetStatus
+	//
+	// Deprecated: use Writer.Status(), which is recorded however the
+	// response is written
+	StatusCode int
 }
 
 // JSONData is a map[string]interface{}.
 type JSONData map[string]interface{}
 
+// defaultParamsCap is the initial capacity of Context.RouteParams.
+// It grows automatically and the grown slice is kept for re-use.
+const defaultParamsCap = 8
+
 // ctxPool is the context pool for re-using context object.
 var ctxPool = sync.Pool{
 	New: func() interface{} {
-		return new(Context)
+		return &Context{
+			RouteParams: make(Params, 0, defaultParamsCap),
+		}
 	},
 }
 
 var (
 	HTTP404Handler func(c *Context)
+	HTTP405Handler func(c *Context) // "Allow" header is set before it is called
+	HTTP406Handler func(c *Context) // called if no predicate of the route is satisfied
 	HTTP500Handler func(c *Context)
 )
 
@@ -50,6 +75,12 @@ func init() {
 	HTTP404Handler = func(c *Context) {
 		c.Fail(http.StatusNotFound, fmt.Sprintf("404 NOT FOUND: %s\n", c.Path))
 	}
+	HTTP405Handler = func(c *Context) {
+		c.Fail(http.StatusMethodNotAllowed, fmt.Sprintf("405 METHOD NOT ALLOWED: %s %s\n", c.Method, c.Path))
+	}
+	HTTP406Handler = func(c *Context) {
+		c.Fail(http.StatusNotAcceptable, fmt.Sprintf("406 NOT ACCEPTABLE: %s %s\n", c.Method, c.Path))
+	}
 	HTTP500Handler = func(c *Context) {
 		c.Fail(http.StatusInternalServerError, "Internal Server Error")
 	}
@@ -64,7 +95,9 @@ func NewContext(rw http.ResponseWriter, r *http.Request) *Context {
 
 // Init sets the initinal values for new context.
 func (c *Context) Init(rw http.ResponseWriter, r *http.Request) {
-	c.ResponseWriter = rw
+	c.writer.reset(rw)
+	c.Writer = &c.writer
+	c.ResponseWriter = &c.writer
 	c.Request = r
 
 	c.Method = r.Method
@@ -73,14 +106,17 @@ func (c *Context) Init(rw http.ResponseWriter, r *http.Request) {
 
 // Free frees the context object and put it into context pool.
 func (c *Context) Free() {
+	c.writer.reset(nil)
+	c.Writer = nil
 	c.ResponseWriter = nil
 	c.Request = nil
 	c.handlers = nil
 	c.index = 0
 	c.session = nil
+	c.engine = nil
 	c.Method = ""
 	c.Path = ""
-	c.RouteParams = nil
+	c.RouteParams = c.RouteParams[:0]
 	c.StatusCode = 0
 	ctxPool.Put(c)
 }
@@ -130,8 +166,7 @@ func (c *Context) GetSession() session.Session {
 
 // GetRouteParam returns route parameters.
 func (c *Context) GetRouteParam(key string) string {
-	value := c.RouteParams[key]
-	return value
+	return c.RouteParams.ByName(key)
 }
 
 // Wrapper function of (*http.Request).FormValue(key string) string.
@@ -163,29 +198,28 @@ func (c *Context) Data(code int, data []byte) {
 
 // HTML responses http request by returning a static html page
 func (c *Context) HTML(code int, html string) {
-	c.SetHeader("Content-Type", "text/html")
-	c.StatusCode = code
-	c.Data(code, []byte(html))
+	c.Render(code, renderFunc("text/html", func(w io.Writer) error {
+		_, err := io.WriteString(w, html)
+		return err
+	}))
 }
 
 // HTMLTemplate responses http request by returning a html page according to template specified.
 func (c *Context) HTMLTemplate(code int, name string, data interface{}) {
-	if view.Manager == nil {
-		log.Error("view manager not exists")
-	}
-
-	c.SetHeader("Content-Type", "text/html")
-	c.StatusCode = code
-	if err := view.Manager.ExecuteTemplate(c.ResponseWriter, name, data); err != nil {
-		log.Error("unable to execute %s", err)
-
-	}
+	c.Render(code, renderFunc("text/html", func(w io.Writer) error {
+		if view.Manager == nil {
+			return errors.New("view manager not exists")
+		}
+		return view.Manager.ExecuteTemplate(w, name, data)
+	}))
 }
 
 // String responses http request by returning plain text
 func (c *Context) String(code int, format string, values ...interface{}) {
-	c.SetHeader("Content-Type", "text/plain")
-	c.Data(code, []byte(fmt.Sprintf(format, values...)))
+	c.Render(code, renderFunc("text/plain", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, format, values...)
+		return err
+	}))
 }
 
 // Fail responses http request by returning error message specified
@@ -195,12 +229,123 @@ func (c *Context) Fail(code int, err string) {
 
 // JSON responses http request by returning JSON object
 func (c *Context) JSON(code int, object interface{}) {
-	c.SetHeader("Content-Type", "application/json")
-	c.SetStatus(code)
-	encoder := json.NewEncoder(c.ResponseWriter)
-	if err := encoder.Encode(object); err != nil {
-		panic(err)
+	c.Render(code, renderFunc("application/json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(object)
+	}))
+}
+
+// SecureJSONPrefix is prepended to the response of (*Context).SecureJSON.
+var SecureJSONPrefix = "while(1);"
+
+// jsonpCallback matches valid JSONP callback, i.e. "jQuery.cb_1".
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// IndentedJSON responses http request by returning JSON object indented
+// for reading, it costs more than JSON so use it for debugging only.
+func (c *Context) IndentedJSON(code int, object interface{}) {
+	c.Render(code, renderFunc("application/json", func(w io.Writer) error {
+		data, err := json.MarshalIndent(object, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}))
+}
+
+// SecureJSON responses http request by returning JSON object prefixed by
+// SecureJSONPrefix, so that JSON array can not be hijacked as script.
+func (c *Context) SecureJSON(code int, object interface{}) {
+	c.Render(code, renderFunc("application/json", func(w io.Writer) error {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append([]byte(SecureJSONPrefix), data...))
+		return err
+	}))
+}
+
+// AsciiJSON responses http request by returning JSON object with
+// non-ASCII characters escaped, i.e. "日本" as "\u65e5\u672c".
+func (c *Context) AsciiJSON(code int, object interface{}) {
+	c.Render(code, renderFunc("application/json", func(w io.Writer) error {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		for _, r := range string(data) {
+			if r < utf8.RuneSelf {
+				buf.WriteRune(r)
+				continue
+			}
+			r1, r2 := utf16.EncodeRune(r)
+			if r1 == utf8.RuneError {
+				fmt.Fprintf(&buf, "\\u%04x", r)
+			} else {
+				fmt.Fprintf(&buf, "\\u%04x\\u%04x", r1, r2)
+			}
+		}
+		_, err = buf.WriteTo(w)
+		return err
+	}))
+}
+
+// JSONP responses http request by returning JSON object wrapped by the
+// callback given in query "callback", i.e. "cb({...});", as JavaScript.
+// It is the same as JSON if callback is not given, and 400 is responsed
+// if callback is not a valid JavaScript identifier.
+func (c *Context) JSONP(code int, object interface{}) {
+	callback := c.GetQuery("callback")
+	if callback == "" {
+		c.JSON(code, object)
+		return
 	}
+	if !jsonpCallback.MatchString(callback) {
+		c.Fail(http.StatusBadRequest, "invalid JSONP callback")
+		return
+	}
+
+	c.SetHeader("X-Content-Type-Options", "nosniff")
+	c.Render(code, renderFunc("application/javascript", func(w io.Writer) error {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte("/**/" + callback + "(" + string(data) + ");"))
+		return err
+	}))
+}
+
+// NDJSON responses http request by returning elements of slice or array
+// as newline delimited JSON, one element per line.
+func (c *Context) NDJSON(code int, slice interface{}) {
+	c.Render(code, renderFunc("application/x-ndjson", func(w io.Writer) error {
+		v := reflect.ValueOf(slice)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("NDJSON requires slice or array, got %T", slice)
+		}
+
+		encoder := json.NewEncoder(w)
+		for i := 0; i < v.Len(); i++ {
+			if err := encoder.Encode(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// XML responses http request by returning XML object
+func (c *Context) XML(code int, object interface{}) {
+	c.Render(code, renderFunc("application/xml", func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(object)
+	}))
 }
 
 // Redirect redicects route to another path.
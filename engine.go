@@ -2,11 +2,14 @@ package umeshu
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os/signal"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
+	"github.com/knchan0x/umeshu/container"
 	"github.com/knchan0x/umeshu/log"
 	"github.com/knchan0x/umeshu/session"
 	"github.com/knchan0x/umeshu/view"
@@ -17,8 +20,65 @@ import (
 // Use New() or Default() to create it.
 type Engine struct {
 	*routerGroup
-	groups   []*routerGroup
-	shutdown context.CancelFunc
+	router    Router // each engine owns its router
+	groups    []*routerGroup
+	hosts     atomic.Value            // []*hostRouter, routers of hosts, static hosts first
+	names     map[string][]routeID    // route name -> routes named, one per method
+	routes    map[routeID]*routeEntry // registered routes, for resolving middlewares
+	conflicts RouteErrors             // errors of registering routes
+	shutdown  context.CancelFunc
+
+	// custom validators, map[string]ValidatorFunc, see RegisterValidator
+	validators atomic.Value
+
+	// protects groups, names, routes and conflicts, so that routes can be
+	// registered while http.Server is serving http requests. Validators
+	// are copied on write under it and read without lock.
+	mu sync.RWMutex
+
+	// Strict refuses to start the server if any route is invalid or
+	// conflicts with other routes, see (*Engine).Validate. Otherwise
+	// conflicting routes are logged as warning and not registered.
+	Strict bool
+
+	// HandleHEAD answers HEAD requests by the GET handler of the same
+	// route if no HEAD handler is registered. The handler runs as usual
+	// but the response body is discarded.
+	HandleHEAD bool
+
+	// HandleOPTIONS answers OPTIONS requests with the methods allowed
+	// for the route in "Allow" header if no OPTIONS handler is registered.
+	HandleOPTIONS bool
+
+	// RedirectTrailingSlash redirects the request to the same path with or
+	// without trailing slash if only that one is registered, i.e. "/users/"
+	// to "/users". 301 is used for GET request and 308 for others.
+	RedirectTrailingSlash bool
+
+	// RedirectFixedPath cleans the request path following the semantics of
+	// path.Clean, i.e. removing "..", "." and double slashes, and redirects
+	// the request to the cleaned path if it is registered, i.e. "//users"
+	// to "/users". 301 is used for GET request and 308 for others.
+	RedirectFixedPath bool
+
+	// RedirectCaseInsensitive works with RedirectFixedPath, it matches the
+	// cleaned path case-insensitively and redirects the request to the
+	// registered one, i.e. "/Users" to "/users".
+	RedirectCaseInsensitive bool
+
+	// UseRawPath routes requests by escaped path, i.e. r.URL.EscapedPath(),
+	// instead of r.URL.Path, so that "/files/a%2Fb" is matched by "/files/:name"
+	// rather than "/files/:name/:sub". Only "%2F" and "%25" are kept escaped
+	// when matching, so that "/café/:id" matches "/caf%C3%A9/1". Values of
+	// route parameters are unescaped after matching, i.e. "a/b". Context.Path
+	// is the path matched, i.e. "/café/a%2Fb".
+	UseRawPath bool
+
+	// AnyMethods are the methods registered by (*routerGroup).Any and
+	// Mount, the standard methods defined by HTTPMethodType by default.
+	// Append methods before registering routes to serve them as well,
+	// i.e. WebDAV methods.
+	AnyMethods []string
 }
 
 // HandlerFunc defines the request handler.
@@ -27,6 +87,19 @@ type HandlerFunc func(*Context)
 // HandlerChain defines a slice of HandlerFunc for internal use.
 type handlerChain []HandlerFunc
 
+// WrapH wraps http.Handler into HandlerFunc, so that it can be
+// registered as a route handler or used as middleware.
+func WrapH(h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		h.ServeHTTP(c.ResponseWriter, c.Request)
+	}
+}
+
+// WrapF wraps http.HandlerFunc into HandlerFunc.
+func WrapF(f http.HandlerFunc) HandlerFunc {
+	return WrapH(f)
+}
+
 // FuncMap is a wrapper of map[string]interface{}, it use to pass
 // FuncMap to HTML template render.
 type FuncMap map[string]interface{}
@@ -35,9 +108,19 @@ type FuncMap map[string]interface{}
 // It is also act as the first routerGroup with empty prefix.
 func New() *Engine {
 	e := &Engine{
+		router: NewRouter(),
 		groups: []*routerGroup{},
+		names:  make(map[string][]routeID),
+		routes: make(map[routeID]*routeEntry),
+		AnyMethods: []string{
+			http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodDelete, http.MethodTrace,
+			http.MethodOptions, http.MethodConnect, http.MethodPatch,
+		},
 	}
-	e.routerGroup = newRouterGroup("", e)
+	e.hosts.Store([]*hostRouter{})
+	e.validators.Store(map[string]ValidatorFunc{})
+	e.routerGroup = newRouterGroup("", nil, nil, e)
 	return e
 }
 
@@ -52,7 +135,11 @@ func Default() *Engine {
 // ServeHTTP conforms to http.Handler interface.
 func (e *Engine) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	context := NewContext(rw, r)
-	e.routerGroup.router.handle(context)
+	context.engine = e
+	if e.UseRawPath {
+		context.Path = rawRoutingPath(r.URL.EscapedPath())
+	}
+	e.routerFor(context).handle(context)
 	context.Free()
 }
 
@@ -85,18 +172,16 @@ func (e *Engine) Shutdown() {
 //
 // Internally, it will start a new goroutine to monitoring
 // shutdown signal.
-//
-// It will also apply middlewares to all registered routes
-// i.e. automaticlly calls (*Engine).ApplyMiddleware()
 func (e *Engine) prepareServer(addr string) *http.Server {
+	if err := e.Validate(); err != nil && e.Strict {
+		log.Panic("unable to run Umeshu engine in strict mode, %s", err)
+	}
+
 	srv := &http.Server{
 		Addr:    addr,
 		Handler: e,
 	}
 
-	// apply middlewares
-	e.ApplyMiddleware()
-
 	// starts a new goroutine to monitor shutdown signal
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	e.shutdown = cancel
@@ -111,47 +196,182 @@ func (e *Engine) prepareServer(addr string) *http.Server {
 		}
 	}()
 
+	e.logRoutes()
 	log.Info("Umeshu is listening and serving HTTP on %s\n", addr)
 	return srv
 }
 
 // ApplyMiddleware apply middlewares on all registered routes.
 //
-// Warning: this function must be invoked before http.Server starts
-// Listening and serving http requests. Otherwise, no middlewares
-// will be attached to routes.
+// Middlewares are already resolved on registration and (*routerGroup).Use,
+// from the group registering the route and its ancestors. Calling it
+// resolves middlewares of all routes again, so it is idempotent and
+// safe to call more than once.
 func (e *Engine) ApplyMiddleware() {
-	routes := e.router.allRoutes()
-	for _, route := range routes {
-		e.applyMiddleware(route.Method, route.Pattern)
-	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resolveMiddlewares(nil)
 }
 
-// applyMiddleware combines middlewares and actual HandlerFunc
-// to create a handlerChain and stores it to the route registered.
-func (e *Engine) applyMiddleware(method string, path string) {
-	// add middlewares
-	var chain handlerChain
-	for _, group := range e.groups {
-		if strings.HasPrefix(path, group.basePath) {
-			chain = append(chain, group.middlewares...)
+// resolveMiddlewares combines middlewares and actual HandlerFunc
+// to create a handlerChain and stores it to the routes registered by
+// group and its sub-groups, all routes if group is nil. e.mu must be held.
+func (e *Engine) resolveMiddlewares(group *routerGroup) {
+	// each router is updated once rather than once per route
+	updates := make(map[Router]map[routeKey]handlerChain)
+	for id, route := range e.routes {
+		if group != nil && !route.belongsTo(group) {
+			continue
+		}
+		router := e.routerOf(id)
+		if updates[router] == nil {
+			updates[router] = make(map[routeKey]handlerChain)
 		}
+		updates[router][routeKey{id.method, id.pattern}] = route.handlerChain()
+	}
+	for router, handlers := range updates {
+		router.setHandlers(handlers)
+	}
+}
+
+// routerOf returns the router which route id is registered to.
+func (e *Engine) routerOf(id routeID) Router {
+	if id.host != nil {
+		return id.host.router
 	}
+	return e.router
+}
 
-	e.router.applyMiddlewares(method, path, chain)
+// SetRouter replaces the router of the engine.
+//
+// Warning: routes registered before calling it will not be moved
+// to the new router, call it before registering any route.
+func (e *Engine) SetRouter(router Router) {
+	assert(router != nil, "router must not be nil")
+	e.router = router
 }
 
 // Group creates a new router group.
 func (e *Engine) Group(prefix string) *routerGroup {
 	prefix = cleanPrefix(prefix)
-	return newRouterGroup(prefix, e)
+	return newRouterGroup(prefix, nil, e.routerGroup, e)
+}
+
+// URL generates the URL path of the route named by (*Route).Name.
+// Parameters are given in key value pairs, values are formatted by
+// fmt.Sprint and escaped, i.e. URL("user.show", "id", 42).
+//
+// URL of route bound to a host by (*Engine).Host is scheme-relative
+// with the host, i.e. "//admin.example.com/users/42", parameters of
+// host are given in pairs as well.
+//
+// It returns error if route not exists, parameters are missing or
+// not satisfying the constraints.
+func (e *Engine) URL(name string, pairs ...interface{}) (string, error) {
+	e.mu.RLock()
+	ids, ok := e.names[name]
+	e.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("route %s not exists", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("parameters of route %s must be in key value pairs", name)
+	}
+
+	values := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("parameter key of route %s must be string, got %v", name, pairs[i])
+		}
+		values[key] = fmt.Sprint(pairs[i+1])
+	}
+
+	id := ids[0]
+	url, err := container.BuildPath(id.pattern, values)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate URL of route %s: %s", name, err)
+	}
+	if id.host == nil {
+		return url, nil
+	}
+
+	host, err := id.host.build(values)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate URL of route %s: %s", name, err)
+	}
+	return "//" + host + url, nil
+}
+
+// nameRoute stores the name of routes, the same name can be given to
+// routes of different methods with the same host and pattern only, it
+// will panic if name is used by other routes. Routes not registered,
+// i.e. invalid or conflicting ones, are not named.
+func (e *Engine) nameRoute(name string, ids []routeID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	registered := e.names[name]
+	for _, id := range ids {
+		if _, ok := e.routes[id]; !ok {
+			log.Warning("route %s %s is not registered, unable to name it %s", id.method, id.pattern, name)
+			continue
+		}
+		if len(registered) > 0 && (registered[0].host != id.host || registered[0].pattern != id.pattern) {
+			log.Panic("duplicated route name: %s, already used by %s", name, registered[0].pattern)
+		}
+		if !containsRouteID(registered, id) {
+			registered = append(registered, id)
+		}
+	}
+	if len(registered) > 0 {
+		e.names[name] = registered
+	}
+}
+
+// unnameRoute removes names of route id, e.mu must be held.
+func (e *Engine) unnameRoute(id routeID) {
+	for name, ids := range e.names {
+		for i, registered := range ids {
+			if registered != id {
+				continue
+			}
+			if len(ids) == 1 {
+				delete(e.names, name)
+			} else {
+				e.names[name] = append(ids[:i:i], ids[i+1:]...)
+			}
+			break
+		}
+	}
+}
+
+// containsRouteID reports whether id is in ids.
+func containsRouteID(ids []routeID, id routeID) bool {
+	for _, registered := range ids {
+		if registered == id {
+			return true
+		}
+	}
+	return false
 }
 
 // LoadHTMLTemplates loads the templates from folder and stores it
 // in ViewManager, it also stores FuncMap.
+//
+// (*Engine).URL is added to FuncMap as "url" unless it is defined,
+// i.e. {{ url "user.show" "id" .ID }}, funcMap itself is not modified.
 func (e *Engine) LoadHTMLTemplates(folder string, funcMap FuncMap) {
+	funcs := make(FuncMap, len(funcMap)+1)
+	for name, fn := range funcMap {
+		funcs[name] = fn
+	}
+	if _, ok := funcs["url"]; !ok {
+		funcs["url"] = e.URL
+	}
+
 	pattern := folder + "/*"
-	view.NewManager(pattern, view.FuncMap(funcMap))
+	view.NewManager(pattern, view.FuncMap(funcs))
 }
 
 // EnableSession starts session.Manager with settings provided.
@@ -164,13 +384,30 @@ func (e *Engine) EnableSession(settings session.SessionSettings) {
 // Default index page for debug is "/debug/pprof/"
 func (e *Engine) EnablePprof() {
 	for _, r := range pprofRouters {
-		e.addRoute(r.Method, r.Path, r.Handler)
+		e.addRoute(methodName(r.Method), r.Path, r.Handler)
+	}
+}
+
+// addGroup creates new routerGroup and stores in e.groups, e.mu must
+// be held. It will panic if group name is deplicated.
+func (e *Engine) addGroup(prefix string, host *hostRouter, parent *routerGroup) *routerGroup {
+	if e.isDeplicate(prefix, host) {
+		log.Panic("duplicated group name: %s, Umeshu may not run as you expected", prefix)
+	}
+
+	newGroup := &routerGroup{
+		basePath: prefix,
+		host:     host,
+		parent:   parent,
+		engine:   e,
 	}
+	e.groups = append(e.groups, newGroup)
+	return newGroup
 }
 
-func (e *Engine) isDeplicate(prefix string) bool {
+func (e *Engine) isDeplicate(prefix string, host *hostRouter) bool {
 	for _, group := range e.groups {
-		if prefix == group.basePath {
+		if prefix == group.basePath && host == group.host {
 			return true
 		}
 	}
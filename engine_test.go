@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/knchan0x/umeshu/container"
 	"github.com/knchan0x/umeshu/log"
 )
 
@@ -53,3 +56,580 @@ func BenchmarkNewContext_Pool(b *testing.B) {
 	}()
 	app.Run(":8080")
 }
+
+func TestEngine_ownRouter(t *testing.T) {
+	api := New()
+	api.GET("/", func(c *Context) { c.String(http.StatusOK, "api") })
+	api.GET("/users", func(c *Context) { c.String(http.StatusOK, "users") })
+
+	admin := New()
+	admin.GET("/", func(c *Context) { c.String(http.StatusOK, "admin") })
+	admin.GET("/dashboard", func(c *Context) { c.String(http.StatusOK, "dashboard") })
+
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+	adminServer := httptest.NewServer(admin)
+	defer adminServer.Close()
+
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{apiServer.URL + "/", http.StatusOK, "api"},
+		{apiServer.URL + "/users", http.StatusOK, "users"},
+		{apiServer.URL + "/dashboard", http.StatusNotFound, "404 404 NOT FOUND: /dashboard\n"},
+		{adminServer.URL + "/", http.StatusOK, "admin"},
+		{adminServer.URL + "/dashboard", http.StatusOK, "dashboard"},
+		{adminServer.URL + "/users", http.StatusNotFound, "404 404 NOT FOUND: /users\n"},
+	}
+
+	// both engines serve at the same time
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		for _, tt := range tests {
+			wg.Add(1)
+			go func(url string, code int, body string) {
+				defer wg.Done()
+				resp, err := http.Get(url)
+				if err != nil {
+					t.Errorf("get %s: %s", url, err)
+					return
+				}
+				defer resp.Body.Close()
+				bodyBytes, _ := ioutil.ReadAll(resp.Body)
+				if resp.StatusCode != code || string(bodyBytes) != body {
+					t.Errorf("get %s = (%d, %q), want (%d, %q)", url, resp.StatusCode, bodyBytes, code, body)
+				}
+			}(tt.url, tt.code, tt.body)
+		}
+	}
+	wg.Wait()
+}
+
+func TestEngine_URL(t *testing.T) {
+	e := New()
+	h := func(c *Context) {}
+	e.GET("/users/:id", h).Name("user.show")
+	e.GET("/users/:id/posts/:post", h).Name("user.post")
+	e.GET("/assets/*filepath", h).Name("assets")
+	e.Group("/v1").POST("/items", h).Name("v1.items")
+	e.Host("admin.example.com").GET("/users/:id", h).Name("admin.user")
+	e.Host(":tenant.example.com").GET("/", h).Name("tenant.home")
+
+	tests := []struct {
+		name  string
+		pairs []interface{}
+		want  string
+		err   bool
+	}{
+		{"user.show", []interface{}{"id", "42"}, "/users/42", false},
+		{"user.show", []interface{}{"id", 42}, "/users/42", false},
+		{"user.show", []interface{}{"id", "a b/c"}, "/users/a%20b%2Fc", false},
+		{"user.show", []interface{}{"id", "用戶"}, "/users/%E7%94%A8%E6%88%B6", false},
+		{"user.post", []interface{}{"id", "1", "post", "2"}, "/users/1/posts/2", false},
+		{"assets", []interface{}{"filepath", "css/main file.css"}, "/assets/css/main%20file.css", false},
+		{"v1.items", nil, "/v1/items", false},
+		{"admin.user", []interface{}{"id", 42}, "//admin.example.com/users/42", false},
+		{"tenant.home", []interface{}{"tenant", "acme"}, "//acme.example.com/", false},
+		{"tenant.home", []interface{}{"tenant", "a.b"}, "", true},
+		{"tenant.home", nil, "", true},
+		{"user.show", nil, "", true},
+		{"user.post", []interface{}{"id", "1"}, "", true},
+		{"user.show", []interface{}{"id"}, "", true},
+		{"user.unknown", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		url, err := e.URL(tt.name, tt.pairs...)
+		if (err != nil) != tt.err {
+			t.Errorf("URL(%s, %v) error = %v, want error %t", tt.name, tt.pairs, err, tt.err)
+		}
+		if url != tt.want {
+			t.Errorf("URL(%s, %v) = %q, want %q", tt.name, tt.pairs, url, tt.want)
+		}
+	}
+}
+
+func TestEngine_routeNames(t *testing.T) {
+	e := New()
+	h := func(c *Context) {}
+	e.GET("/login", h).Name("login")
+	e.POST("/login", h).Name("login")
+	e.Host("admin.example.com").GET("/", h).Name("admin.home")
+
+	tests := []struct {
+		name   string
+		host   string
+		method string
+		path   string
+		panic  bool
+	}{
+		{"login", "", http.MethodPut, "/login", false},
+		{"login", "", http.MethodGet, "/logout", true},
+		{"login", "admin.example.com", http.MethodGet, "/login", true},
+		{"admin.home", "", http.MethodGet, "/", true},
+		{"admin.home", "admin.example.com", http.MethodPost, "/", false},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if panicked := recover() != nil; panicked != tt.panic {
+					t.Errorf("Name(%s) of %s %s%s panicked = %t, want %t", tt.name, tt.method, tt.host, tt.path, panicked, tt.panic)
+				}
+			}()
+			g := e.routerGroup
+			if tt.host != "" {
+				g = e.Host(tt.host)
+			}
+			g.Handle(tt.method, tt.path, h).Name(tt.name)
+		}()
+	}
+}
+
+func TestEngine_URLConflict(t *testing.T) {
+	e := New()
+	h := func(c *Context) {}
+	e.GET("/users/:id", h)
+	e.GET("/users/:name", h).Name("byname") // shadowed by "/users/:id"
+	e.Handle("bad method", "/users", h).Name("bad")
+
+	for _, name := range []string{"byname", "bad"} {
+		if url, err := e.URL(name, "name", "bob"); err == nil {
+			t.Errorf("URL(%s) of route failed to register = %q, want error", name, url)
+		}
+	}
+}
+
+func TestEngine_URLConstraint(t *testing.T) {
+	e := New()
+	e.GET("/users/:id<int>", func(c *Context) {}).Name("user.show")
+
+	if url, err := e.URL("user.show", "id", 42); err != nil || url != "/users/42" {
+		t.Errorf("URL(user.show, id, 42) = (%q, %v), want %q", url, err, "/users/42")
+	}
+	if _, err := e.URL("user.show", "id", "abc"); err == nil {
+		t.Errorf("URL(user.show, id, abc) should return error")
+	}
+}
+
+func TestEngine_liveRegistration(t *testing.T) {
+	e := New()
+	e.Use(func(c *Context) {
+		c.ResponseWriter.Header().Add("X-Middleware", "1")
+		c.Next()
+	})
+	e.GET("/", func(c *Context) { c.String(http.StatusOK, "index") })
+	e.ApplyMiddleware()
+	e.ApplyMiddleware() // no effect
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+		return rw
+	}
+
+	// lookups run concurrently with registration
+	stop := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if rw := get("/"); rw.Code != http.StatusOK || rw.Body.String() != "index" {
+						t.Errorf("get / = (%d, %q) during registration", rw.Code, rw.Body.String())
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		i := i
+		e.GET(fmt.Sprintf("/feature/%d", i), func(c *Context) { c.String(http.StatusOK, "v1-%d", i) })
+	}
+	e.GET("/feature/7", func(c *Context) { c.String(http.StatusOK, "v2-7") }) // replace
+	e.GET("/feature/8", func(c *Context) {}).Name("feature8")
+	if !e.Remove(HTTP_GET, "/feature/8") {
+		t.Errorf("remove registered route should return true")
+	}
+	if e.Remove(HTTP_GET, "/feature/8") {
+		t.Errorf("remove unregistered route should return false")
+	}
+	close(stop)
+	wg.Wait()
+
+	if url, err := e.URL("feature8"); err == nil {
+		t.Errorf("URL(feature8) of removed route = %q, want error", url)
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/feature/1", http.StatusOK, "v1-1"},
+		{"/feature/7", http.StatusOK, "v2-7"},
+		{"/feature/8", http.StatusNotFound, "404 404 NOT FOUND: /feature/8\n"},
+	}
+	for _, tt := range tests {
+		rw := get(tt.path)
+		if rw.Code != tt.code || rw.Body.String() != tt.body {
+			t.Errorf("get %s = (%d, %q), want (%d, %q)", tt.path, rw.Code, rw.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	// middlewares are applied to routes registered late
+	if rw := get("/feature/1"); rw.Header().Get("X-Middleware") != "1" {
+		t.Errorf("middleware should be applied to routes registered after ApplyMiddleware")
+	}
+	if rw := get("/"); len(rw.Header()["X-Middleware"]) != 1 {
+		t.Errorf("middleware should be applied once")
+	}
+}
+
+// chainTestMiddleware adds name to "X-Chain" header, so that the order
+// of middlewares can be checked.
+func chainTestMiddleware(name string) HandlerFunc {
+	return func(c *Context) {
+		c.ResponseWriter.Header().Add("X-Chain", name)
+		c.Next()
+	}
+}
+
+func TestEngine_middlewareResolution(t *testing.T) {
+	e := New()
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+
+	e.Use(chainTestMiddleware("engine"))
+	api := e.Group("/api")
+	api.Use(chainTestMiddleware("api"))
+	api.GET("/users", ok)
+	v1 := api.SubGroup("/v1")
+	v1.GET("/users", ok)
+	apiv2 := e.Group("/apiv2")
+	apiv2.GET("/users", ok)
+	e.GET("/api/direct", ok) // registered by engine, not by "/api" group
+
+	// middlewares attached after registration
+	v1.Use(chainTestMiddleware("v1"))
+	e.Use(chainTestMiddleware("late"))
+
+	e.ApplyMiddleware()
+	e.ApplyMiddleware() // resolving again has no effect
+
+	tests := []struct {
+		path  string
+		chain []string
+	}{
+		{"/api/users", []string{"engine", "late", "api"}},
+		{"/api/v1/users", []string{"engine", "late", "api", "v1"}},
+		{"/apiv2/users", []string{"engine", "late"}},
+		{"/api/direct", []string{"engine", "late"}},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if chain := rw.Header()["X-Chain"]; fmt.Sprint(chain) != fmt.Sprint(tt.chain) {
+			t.Errorf("get %s: middlewares = %v, want %v", tt.path, chain, tt.chain)
+		}
+	}
+}
+
+func TestEngine_routeMiddleware(t *testing.T) {
+	e := New()
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+
+	e.Use(chainTestMiddleware("engine"))
+	api := e.Group("/api")
+	api.GET("/users", chainTestMiddleware("auth"), chainTestMiddleware("audit"), ok)
+	api.GET("/public", ok)
+	api.Any("/any", chainTestMiddleware("any"), ok)
+
+	// route middlewares are kept when group middlewares are resolved again
+	api.Use(chainTestMiddleware("api"))
+
+	tests := []struct {
+		method string
+		path   string
+		chain  []string
+	}{
+		{http.MethodGet, "/api/users", []string{"engine", "api", "auth", "audit"}},
+		{http.MethodGet, "/api/public", []string{"engine", "api"}},
+		{http.MethodPost, "/api/any", []string{"engine", "api", "any"}},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if rw.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rw.Code, http.StatusOK)
+		}
+		if chain := rw.Header()["X-Chain"]; fmt.Sprint(chain) != fmt.Sprint(tt.chain) {
+			t.Errorf("%s %s: middlewares = %v, want %v", tt.method, tt.path, chain, tt.chain)
+		}
+	}
+}
+
+func TestEngine_Mount(t *testing.T) {
+	e := New()
+	sub := New()
+	sub.GET("/", func(c *Context) { c.String(http.StatusOK, "sub index") })
+	sub.GET("/users/:id", func(c *Context) { c.String(http.StatusOK, "sub user %s", c.GetRouteParam("id")) })
+
+	legacy := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, "legacy %s %s", r.Method, r.URL.Path)
+	})
+
+	e.Use(chainTestMiddleware("engine"))
+	api := e.Group("/api")
+	api.Mount("/sub", sub, chainTestMiddleware("mount"))
+	e.Mount("/legacy", legacy).Name("legacy")
+	e.Mount("/beta", legacy).Header("X-Beta", "1")
+	e.GET("/wrap", WrapF(legacy))
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		chain  []string
+	}{
+		{http.MethodGet, "/api/sub", "sub index", []string{"engine", "mount"}},
+		{http.MethodGet, "/api/sub/", "sub index", []string{"engine", "mount"}},
+		{http.MethodGet, "/api/sub/users/42", "sub user 42", []string{"engine", "mount"}},
+		{http.MethodPost, "/legacy/a/b", "legacy POST /a/b", []string{"engine"}},
+		{http.MethodDelete, "/legacy", "legacy DELETE /", []string{"engine"}},
+		{http.MethodGet, "/wrap", "legacy GET /wrap", []string{"engine"}},
+		{http.MethodGet, "/beta", "406 406 NOT ACCEPTABLE: GET /beta\n", []string{"engine"}},
+		{http.MethodGet, "/beta/a", "406 406 NOT ACCEPTABLE: GET /beta/a\n", []string{"engine"}},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if body := rw.Body.String(); body != tt.body {
+			t.Errorf("%s %s: body = %q, want %q", tt.method, tt.path, body, tt.body)
+		}
+		if chain := rw.Header()["X-Chain"]; fmt.Sprint(chain) != fmt.Sprint(tt.chain) {
+			t.Errorf("%s %s: middlewares = %v, want %v", tt.method, tt.path, chain, tt.chain)
+		}
+	}
+
+	// route returned covers the prefix as well
+	if url, err := e.URL("legacy"); err != nil || url != "/legacy" {
+		t.Errorf("URL(legacy) = (%q, %v), want %q", url, err, "/legacy")
+	}
+	for path, want := range map[string]string{"/beta": "legacy GET /", "/beta/a": "legacy GET /a"} {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-Beta", "1")
+		e.ServeHTTP(rw, r)
+		if body := rw.Body.String(); body != want {
+			t.Errorf("get %s with X-Beta: body = %q, want %q", path, body, want)
+		}
+	}
+
+	// mount at root
+	root := New()
+	root.Mount("", legacy)
+	rw := httptest.NewRecorder()
+	root.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/a/b", nil))
+	if body := rw.Body.String(); body != "legacy GET /a/b" {
+		t.Errorf("get /a/b of root mount: body = %q, want %q", body, "legacy GET /a/b")
+	}
+}
+
+func TestEngine_optionalParams(t *testing.T) {
+	e := New()
+	e.GET("/posts/:page<int>?", func(c *Context) {
+		c.String(http.StatusOK, "page %s", c.GetRouteParam("page"))
+	}).Default("page", "1").Name("posts")
+	e.GET("/archive/:year?/:month?", func(c *Context) {
+		c.String(http.StatusOK, "%s-%s", c.GetRouteParam("year"), c.GetRouteParam("month"))
+	}).Default("month", "01").Default("year", "2020").Default("month", "12")
+	e.GET("/posts/new", func(c *Context) { c.String(http.StatusOK, "new post") })
+
+	// defaults are kept when middlewares are resolved again
+	e.Use(func(c *Context) { c.Next() })
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/posts", "page 1"},
+		{"/posts/3", "page 3"},
+		{"/posts/new", "new post"},
+		{"/archive", "2020-12"},
+		{"/archive/2021", "2021-12"},
+		{"/archive/2021/10", "2021-10"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if body := rw.Body.String(); body != tt.body {
+			t.Errorf("get %s: body = %q, want %q", tt.path, body, tt.body)
+		}
+	}
+
+	for _, tt := range []struct {
+		pairs []interface{}
+		want  string
+	}{
+		{nil, "/posts"},
+		{[]interface{}{"page", 2}, "/posts/2"},
+	} {
+		if url, err := e.URL("posts", tt.pairs...); err != nil || url != tt.want {
+			t.Errorf("URL(posts, %v) = (%q, %v), want %q", tt.pairs, url, err, tt.want)
+		}
+	}
+	if err := e.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	// invalid defaults are not set but reported
+	e.GET("/pages/:page<int>?", func(c *Context) {
+		c.String(http.StatusOK, "page %s", c.GetRouteParam("page"))
+	}).Default("page", "abc").Default("nope", "x")
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/pages", nil))
+	if body := rw.Body.String(); body != "page " {
+		t.Errorf("get /pages: body = %q, want %q", body, "page ")
+	}
+	err := e.Validate()
+	if errs, ok := err.(RouteErrors); !ok || len(errs) != 2 || errs[0].Kind != container.InvalidPattern {
+		t.Errorf("Validate() = %v, want 2 errors of invalid defaults", err)
+	}
+}
+
+func TestEngine_UseRawPath(t *testing.T) {
+	e := New()
+	e.GET("/files/:name", func(c *Context) { c.String(http.StatusOK, "file %s", c.GetRouteParam("name")) })
+	e.GET("/files/:name/:sub", func(c *Context) { c.String(http.StatusOK, "sub %s", c.GetRouteParam("sub")) })
+	e.GET("/static/*path", func(c *Context) { c.String(http.StatusOK, "static %s", c.GetRouteParam("path")) })
+	e.GET("/café/:id", func(c *Context) { c.String(http.StatusOK, "café %s", c.GetRouteParam("id")) })
+	e.GET("/a b/:id", func(c *Context) { c.String(http.StatusOK, "a b %s", c.GetRouteParam("id")) })
+
+	tests := []struct {
+		path       string
+		useRawPath bool
+		body       string
+	}{
+		{"/files/a%2Fb", false, "sub b"},
+		{"/files/a%2Fb", true, "file a/b"},
+		{"/files/hello%20world", true, "file hello world"},
+		{"/files/%E6%97%A5%E6%9C%AC", true, "file 日本"},
+		{"/files/100%25", true, "file 100%"},
+		{"/static/a%2Fb/c%20d.txt", true, "static a/b/c d.txt"},
+		{"/static/a%2Fb/c%20d.txt", false, "static a/b/c d.txt"},
+		{"/caf%C3%A9/1", true, "café 1"},
+		{"/caf%c3%a9/a%2Fb", true, "café a/b"},
+		{"/caf%C3%A9/1", false, "café 1"},
+		{"/a%20b/1", true, "a b 1"},
+		{"/a%20b/1", false, "a b 1"},
+	}
+
+	for _, tt := range tests {
+		e.UseRawPath = tt.useRawPath
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if body := rw.Body.String(); body != tt.body {
+			t.Errorf("get %s with UseRawPath %t: body = %q, want %q", tt.path, tt.useRawPath, body, tt.body)
+		}
+	}
+
+	// redirect keeps the path escaped
+	e.UseRawPath = true
+	e.RedirectTrailingSlash = true
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/files/a%2Fb/", nil))
+	if location := rw.Header().Get("Location"); location != "/files/a%2Fb" {
+		t.Errorf("redirect /files/a%%2Fb/: location = %q, want %q", location, "/files/a%2Fb")
+	}
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/caf%C3%A9/a%2Fb%20c/", nil))
+	if location := rw.Header().Get("Location"); location != "/caf%C3%A9/a%2Fb%20c" {
+		t.Errorf("redirect /caf%%C3%%A9/a%%2Fb%%20c/: location = %q, want %q", location, "/caf%C3%A9/a%2Fb%20c")
+	}
+}
+
+func TestEngine_customMethods(t *testing.T) {
+	e := New()
+	e.HandleOPTIONS = true
+	e.AnyMethods = append(e.AnyMethods, "PROPFIND", "MKCOL")
+
+	method := func(c *Context) { c.String(http.StatusOK, "%s %s", c.Method, c.Path) }
+	e.Handle("PROPFIND", "/files/*path", method)
+	e.Handle("REPORT", "/files/*path", method)
+	e.Handle("bad method", "/files/*path", method)
+	e.Match([]string{http.MethodGet, http.MethodPost}, "/login", method)
+	e.Mount("/dav", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, "dav %s %s", r.Method, r.URL.Path)
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{"PROPFIND", "/files/a.txt", http.StatusOK, "PROPFIND /files/a.txt"},
+		{"REPORT", "/files/a.txt", http.StatusOK, "REPORT /files/a.txt"},
+		{"propfind", "/files/a.txt", http.StatusMethodNotAllowed, "405 405 METHOD NOT ALLOWED: propfind /files/a.txt\n"},
+		{http.MethodGet, "/login", http.StatusOK, "GET /login"},
+		{http.MethodPost, "/login", http.StatusOK, "POST /login"},
+		{http.MethodPut, "/login", http.StatusMethodNotAllowed, "405 405 METHOD NOT ALLOWED: PUT /login\n"},
+		{"MKCOL", "/dav/docs", http.StatusOK, "dav MKCOL /docs"},
+		{"PROPFIND", "/dav", http.StatusOK, "dav PROPFIND /"},
+		{"COPY", "/dav/docs", http.StatusMethodNotAllowed, "405 405 METHOD NOT ALLOWED: COPY /dav/docs\n"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if rw.Code != tt.code || rw.Body.String() != tt.body {
+			t.Errorf("%s %s: (%d, %q), want (%d, %q)", tt.method, tt.path, rw.Code, rw.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodOptions, "/files/a.txt", nil))
+	if allow := rw.Header().Get("Allow"); allow != "OPTIONS, PROPFIND, REPORT" {
+		t.Errorf("Allow = %q, want %q", allow, "OPTIONS, PROPFIND, REPORT")
+	}
+
+	// invalid method is reported
+	err := e.Validate()
+	if errs, ok := err.(RouteErrors); !ok || len(errs) != 1 || errs[0].Kind != container.InvalidPattern || errs[0].Method != "bad method" {
+		t.Errorf("Validate() = %v, want error of invalid method", err)
+	}
+
+	e.SubGroupHandle("/calendars", "REPORT", method).GET("/events", method)
+	for m, path := range map[string]string{"REPORT": "/calendars", http.MethodGet: "/calendars/events"} {
+		rw = httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(m, path, nil))
+		if rw.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, want %d", m, path, rw.Code, http.StatusOK)
+		}
+	}
+
+	if !e.RemoveRoute("PROPFIND", "/files/*path") {
+		t.Errorf("RemoveRoute(PROPFIND, /files/*path) = false, want true")
+	}
+	if e.RemoveRoute("PROPFIND", "/files/*path") {
+		t.Errorf("RemoveRoute(PROPFIND, /files/*path) of removed route = true, want false")
+	}
+	rw = httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest("PROPFIND", "/files/a.txt", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PROPFIND /files/a.txt after removal: status = %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
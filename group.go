@@ -2,10 +2,12 @@ package umeshu
 
 import (
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
-	"github.com/knchan0x/umeshu/log"
+	"github.com/knchan0x/umeshu/container"
 )
 
 // routerGroup is a group of pattern/path with same prefix,
@@ -17,14 +19,175 @@ import (
 // comes in, Umeshu will use router directly for handling
 // request and will by pass routerGroup.
 type routerGroup struct {
-	// all routerGroups share same Router instance,
-	// as Umeshu uses same router for handling all routes.
-	router      Router
+	// all routerGroups of an engine share the engine's Router
+	// instance, i.e. (*Engine).router, as Umeshu uses same router
+	// for handling all routes of an engine. Groups bound to a host
+	// share the Router of the host instead.
 	basePath    string
+	host        *hostRouter  // nil if not bound to any host
+	parent      *routerGroup // nil for the engine itself
 	middlewares []HandlerFunc
 	engine      *Engine
 }
 
+// routeID identifies a registered route of an engine.
+type routeID struct {
+	host    *hostRouter
+	method  string
+	pattern string
+}
+
+// routeEntry records the variants of a registered route, so that
+// handlerChain of the route can be resolved again when middlewares are
+// attached to the group afterwards.
+type routeEntry struct {
+	// conditional variants in order of registration,
+	// then the default one if any
+	variants []*routeVariant
+	defaults Params // default values of optional parameters
+}
+
+// routeVariant records the routerGroup registering the route and the
+// handlers given on registration, i.e. route middlewares and handler.
+type routeVariant struct {
+	group      *routerGroup
+	handlers   handlerChain
+	predicates []Predicate // nil for the default variant
+	conditions []string    // descriptions of predicates
+	vary       []string    // headers predicates depend on
+
+	// the default variant replaced on registration and the error
+	// reported for it, they are restored if the variant turns out
+	// to be a conditional one by (*Route).When
+	replaced *routeVariant
+	conflict *RouteError
+}
+
+// handlerChain returns group middlewares followed by route middlewares
+// and handler. (*Engine).mu must be held.
+func (v *routeVariant) handlerChain() handlerChain {
+	return append(v.group.middlewareChain(), v.handlers...)
+}
+
+// defaultVariant returns the variant without predicate, nil if not any.
+func (r *routeEntry) defaultVariant() *routeVariant {
+	if n := len(r.variants); n > 0 && r.variants[n-1].predicates == nil {
+		return r.variants[n-1]
+	}
+	return nil
+}
+
+// conditionalVariants returns a copy of variants with predicates.
+func (r *routeEntry) conditionalVariants() []*routeVariant {
+	variants := append([]*routeVariant(nil), r.variants...)
+	if r.defaultVariant() != nil {
+		variants = variants[:len(variants)-1]
+	}
+	return variants
+}
+
+// belongsTo reports whether any variant is registered by the group
+// or its sub-groups.
+func (r *routeEntry) belongsTo(group *routerGroup) bool {
+	for _, variant := range r.variants {
+		if variant.group.belongsTo(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerChain returns handlerChain of the default variant if it is
+// the only one, otherwise middlewares shared by all variants followed
+// by a handler choosing the variant by predicates, so that predicates
+// run inside middlewares such as Recovery. (*Engine).mu must be held.
+func (r *routeEntry) handlerChain() handlerChain {
+	if len(r.variants) == 1 && r.variants[0].predicates == nil {
+		return r.variants[0].handlerChain()
+	}
+
+	// groups of variants share middlewares of their common ancestor
+	common := r.variants[0].group
+	for _, variant := range r.variants[1:] {
+		for !variant.group.belongsTo(common) {
+			common = common.parent
+		}
+	}
+	shared := common.middlewareChain()
+	return append(shared, dispatchHandler(r.variants, len(shared)))
+}
+
+// Route represents a registered route, it is returned by the
+// registration methods of routerGroup for further configuration.
+type Route struct {
+	Methods  []string // http methods registered
+	Pattern  string   // full pattern registered, including group prefix
+	engine   *Engine
+	group    *routerGroup
+	variants []*routeVariant // variant registered for each method
+
+	// route of the prefix itself registered by Mount, nil otherwise
+	prefix *Route
+}
+
+// Name names the route, so that its URL can be generated by (*Engine).URL.
+// It will panic if name is duplicated. Route failed to register is not
+// named, (*Engine).URL returns error for it. Route returned by Mount is
+// named by its prefix, i.e. "/legacy" rather than "/legacy/*mountpath".
+func (r *Route) Name(name string) *Route {
+	if r.prefix != nil {
+		r.prefix.Name(name)
+		return r
+	}
+
+	ids := make([]routeID, len(r.Methods))
+	for i, method := range r.Methods {
+		ids[i] = routeID{r.group.host, method, r.Pattern}
+	}
+	r.engine.nameRoute(name, ids)
+	return r
+}
+
+// Default sets the default value of optional parameter, it is stored in
+// Context.RouteParams if the parameter is absent from the request path,
+// i.e. GET("/posts/:page?", handler).Default("page", "1").
+//
+// Default of parameter which is not optional or value not satisfying
+// the constraint is not set, the error is logged and reported by
+// (*Engine).Validate.
+func (r *Route) Default(key string, value string) *Route {
+	e := r.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	invalid := container.CheckDefault(r.Pattern, key, value)
+	for _, method := range r.Methods {
+		id := routeID{r.group.host, method, r.Pattern}
+		route, ok := e.routes[id]
+		if !ok {
+			continue
+		}
+		if invalid != nil {
+			e.addConflict(id, &container.PatternError{
+				Kind:    container.InvalidPattern,
+				Pattern: r.Pattern,
+				Reason:  invalid.Error(),
+			})
+			continue
+		}
+
+		defaults := make(Params, 0, len(route.defaults)+1)
+		for _, p := range route.defaults {
+			if p.Key != key {
+				defaults = append(defaults, p)
+			}
+		}
+		route.defaults = append(defaults, Param{Key: key, Value: value})
+		r.group.router().setDefaults(method, r.Pattern, route.defaults)
+	}
+	return r
+}
+
 type HTTPMethodType int
 
 const (
@@ -39,25 +202,15 @@ const (
 	HTTP_PATCH
 )
 
-// GlobalRouter is the router instance shared by all routerGroup.
-var GlobalRouter Router = NewRouter()
-
-// newRouterGroup returns new routerGroup and stores in (*engine).groups.
+// newRouterGroup returns new routerGroup under parent and stores in (*engine).groups.
 // Essentially router group just adds a prefix to the pattern,
-// all routerGroup shares same router instance and shares the same radix tree.
+// all routerGroup of an engine shares the engine's router instance and
+// shares the same radix tree, except those bound to a host by (*Engine).Host.
 // It will panic if group name is deplicated.
-func newRouterGroup(prefix string, e *Engine) *routerGroup {
-	if e.isDeplicate(prefix) {
-		log.Panic("duplicated group name: %s, Umeshu may not run as you expected", prefix)
-	}
-
-	newGroup := &routerGroup{
-		basePath: prefix,
-		router:   GlobalRouter,
-		engine:   e,
-	}
-	e.groups = append(e.groups, newGroup)
-	return newGroup
+func newRouterGroup(prefix string, host *hostRouter, parent *routerGroup, e *Engine) *routerGroup {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addGroup(prefix, host, parent)
 }
 
 // BasePath returns the base path of routerGroup.
@@ -69,115 +222,308 @@ func (g *routerGroup) BasePath() string {
 // SubGroup creates new sub-routerGroup.
 func (g *routerGroup) SubGroup(prefix string) *routerGroup {
 	prefix = cleanPrefix(prefix)
-	return newRouterGroup(g.basePath+prefix, g.engine)
+	return newRouterGroup(g.basePath+prefix, g.host, g, g.engine)
+}
+
+// router returns the router which routes of the group are registered to.
+func (g *routerGroup) router() Router {
+	if g.host != nil {
+		return g.host.router
+	}
+	return g.engine.router
 }
 
 // SubGroupWithHander creates new sub-routerGroup and defines handler for
 // the sub-routerGroup pattern, i.e. SubGroupWithHander("/v1", umeshu.HTTP_GET, handlerFunc)
 // defining a "/v1" sub-routerGroup - "example.com/v1" and the corresponding handlerFunc for
-// "example.com/v1".
-func (g *routerGroup) SubGroupWithHander(prefix string, method HTTPMethodType, handler HandlerFunc) *routerGroup {
+// "example.com/v1". Route middlewares can be given before handler.
+func (g *routerGroup) SubGroupWithHander(prefix string, method HTTPMethodType, handlers ...HandlerFunc) *routerGroup {
+	return g.SubGroupHandle(prefix, methodName(method), handlers...)
+}
+
+// SubGroupHandle is the same as SubGroupWithHander but accepts any
+// method token, i.e. SubGroupHandle("/dav", "PROPFIND", handlerFunc).
+func (g *routerGroup) SubGroupHandle(prefix string, method string, handlers ...HandlerFunc) *routerGroup {
 	// g.basePath will be added at g.addRoute and g.Subgroup later
 	prefix = cleanPrefix(prefix)
-	g.addRoute(method, prefix, handler)
+	g.addRoute(method, prefix, handlers...)
 	return g.SubGroup(prefix)
 }
 
-// Use attaches middlewares to the router group.
+// Use attaches middlewares to the router group. They apply to routes
+// registered by the group and its sub-groups, including those
+// registered before.
 func (g *routerGroup) Use(middlewares ...HandlerFunc) {
+	g.engine.mu.Lock()
+	defer g.engine.mu.Unlock()
 	g.middlewares = append(g.middlewares, middlewares...)
+	g.engine.resolveMiddlewares(g)
+}
+
+// middlewareChain returns middlewares of the group and its ancestors,
+// ancestors' first. (*Engine).mu must be held.
+func (g *routerGroup) middlewareChain() handlerChain {
+	var chain handlerChain
+	if g.parent != nil {
+		chain = g.parent.middlewareChain()
+	}
+	return append(chain, g.middlewares...)
+}
+
+// belongsTo reports whether the group is ancestor or ancestor's sub-group.
+func (g *routerGroup) belongsTo(ancestor *routerGroup) bool {
+	for group := g; group != nil; group = group.parent {
+		if group == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// GET registers handlers for GET request. The last of handlers is the
+// handler, others are route middlewares which run after group middlewares,
+// i.e. GET("/admin", auth, handler). It is the same for other methods.
+func (g *routerGroup) GET(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodGet, pattern, handlers...)
+}
+
+// HEAD registers handlers for HEAD request.
+func (g *routerGroup) HEAD(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodHead, pattern, handlers...)
+}
+
+// POST registers handlers for POST request.
+func (g *routerGroup) POST(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodPost, pattern, handlers...)
+}
+
+// PUT registers handlers for PUT request.
+func (g *routerGroup) PUT(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodPut, pattern, handlers...)
+}
+
+// DELETE registers handlers for DELETE request.
+func (g *routerGroup) DELETE(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodDelete, pattern, handlers...)
 }
 
-// GET registers handler for GET request.
-func (g *routerGroup) GET(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_GET, pattern, handler)
+// TRACE registers handlers for TRACE request.
+func (g *routerGroup) TRACE(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodTrace, pattern, handlers...)
 }
 
-// HEAD registers handler for HEAD request.
-func (g *routerGroup) HEAD(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_HEAD, pattern, handler)
+// OPTIONS registers handlers for OPTIONS request.
+func (g *routerGroup) OPTIONS(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodOptions, pattern, handlers...)
 }
 
-// POST registers handler for POST request.
-func (g *routerGroup) POST(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_POST, pattern, handler)
+// CONNECT registers handlers for CONNECT request.
+func (g *routerGroup) CONNECT(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodConnect, pattern, handlers...)
 }
 
-// PUT registers handler for PUT request.
-func (g *routerGroup) PUT(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_PUT, pattern, handler)
+// PATCH registers handlers for PATCH request.
+func (g *routerGroup) PATCH(pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(http.MethodPatch, pattern, handlers...)
 }
 
-// DELETE registers handler for DELETE request.
-func (g *routerGroup) DELETE(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_DELETE, pattern, handler)
+// Any registers a route that matches the methods of (*Engine).AnyMethods,
+// by default GET, POST, PUT, PATCH, HEAD, OPTIONS, DELETE, CONNECT, TRACE.
+func (g *routerGroup) Any(pattern string, handlers ...HandlerFunc) *Route {
+	return g.Match(g.engine.AnyMethods, pattern, handlers...)
 }
 
-// TRACE registers handler for TRACE request.
-func (g *routerGroup) TRACE(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_TRACE, pattern, handler)
+// Match registers a route that matches the given methods,
+// i.e. Match([]string{"GET", "POST"}, "/login", handler).
+func (g *routerGroup) Match(methods []string, pattern string, handlers ...HandlerFunc) *Route {
+	route := &Route{engine: g.engine, group: g}
+	for _, method := range methods {
+		r := g.addRoute(method, pattern, handlers...)
+		route.Methods = append(route.Methods, r.Methods...)
+		route.variants = append(route.variants, r.variants...)
+		route.Pattern = r.Pattern
+	}
+	return route
 }
 
-// OPTIONS registers handler for OPTIONS request.
-func (g *routerGroup) OPTIONS(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_OPTIONS, pattern, handler)
+// Handle registers handlers for request of any method, including those
+// not defined by HTTPMethodType, i.e. WebDAV methods such as PROPFIND
+// and MKCOL. Method names are case-sensitive.
+func (g *routerGroup) Handle(method string, pattern string, handlers ...HandlerFunc) *Route {
+	return g.addRoute(method, pattern, handlers...)
 }
 
-// CONNECT registers handler for CONNECT request.
-func (g *routerGroup) CONNECT(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_CONNECT, pattern, handler)
+// Replace is the same as Handle but replaces the handler of the same
+// method and pattern deliberately, no error is reported by
+// (*Engine).Validate for it. The handler is swapped atomically, requests
+// in-flight are finished by the replaced one. The route is registered
+// if it does not exist.
+func (g *routerGroup) Replace(method string, pattern string, handlers ...HandlerFunc) *Route {
+	return g.registerRoute(method, pattern, true, handlers...)
 }
 
-// PATCH registers handler for PATCH request.
-func (g *routerGroup) PATCH(pattern string, handler HandlerFunc) {
-	g.addRoute(HTTP_PATCH, pattern, handler)
+// addRoute registers a new request handle with the given pattern and method.
+// The last of handlers is the handler, others are route middlewares.
+// Handler of the same method and pattern will be replaced, it is safe to
+// call while http.Server is serving http requests.
+//
+// Invalid or conflicting route is not registered, the error is logged
+// and reported by (*Engine).Validate, so is the replaced one. Use
+// Replace to replace it deliberately. Handlers registered with
+// predicates, see (*Route).When, are not replaced.
+func (g *routerGroup) addRoute(method string, pattern string, handlers ...HandlerFunc) *Route {
+	return g.registerRoute(method, pattern, false, handlers...)
 }
 
-// Any registers a route that matches all the HTTP methods, i.e.
-// GET, POST, PUT, PATCH, HEAD, OPTIONS, DELETE, CONNECT, TRACE.
-func (g *routerGroup) Any(pattern string, handler HandlerFunc) {
-	for i := HTTP_GET; i <= HTTP_PATCH; i++ {
-		g.addRoute(i, pattern, handler)
+// registerRoute registers the route like addRoute, no error is reported
+// for replacing the route if replace is true.
+func (g *routerGroup) registerRoute(method string, pattern string, replace bool, handlers ...HandlerFunc) *Route {
+	assert(len(pattern) > 0, "pattern cannot be empty")
+	assert(pattern[0] == '/', "pattern must begin with '/'")
+	assert(len(handlers) > 0, "handler must not be nil")
+	for _, handler := range handlers {
+		assert(handler != nil, "handler must not be nil")
+	}
+
+	pattern = g.fullPattern(pattern)
+
+	e := g.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !validMethod(method) {
+		e.addConflict(routeID{g.host, method, pattern}, &container.PatternError{
+			Kind:    container.InvalidPattern,
+			Pattern: pattern,
+			Reason:  "invalid method " + strconv.Quote(method),
+		})
+		return &Route{Pattern: pattern, engine: g.engine, group: g}
+	}
+
+	route := &Route{
+		Methods:  []string{method},
+		Pattern:  pattern,
+		engine:   g.engine,
+		group:    g,
+		variants: []*routeVariant{{group: g, handlers: append(handlerChain(nil), handlers...)}},
 	}
+	variant := route.variants[0]
+
+	// middlewares are resolved on registration from the group hierarchy
+	id := routeID{g.host, method, pattern}
+	entry, ok := e.routes[id]
+	if !ok {
+		entry = &routeEntry{variants: []*routeVariant{variant}}
+		if err := g.router().addRoute(method, pattern, entry.handlerChain()...); err != nil {
+			e.addConflict(id, err)
+		} else {
+			e.routes[id] = entry
+		}
+		return route
+	}
+
+	// the default variant is replaced, the error is reported once
+	// no matter how many times it is replaced
+	if replaced := entry.defaultVariant(); replaced != nil {
+		variant.replaced = replaced
+		variant.conflict = replaced.conflict
+		if variant.conflict == nil && !replace {
+			variant.conflict = e.addConflict(id, &container.PatternError{
+				Kind:     container.DuplicatePattern,
+				Pattern:  pattern,
+				Existing: pattern,
+				Reason:   "handler is replaced",
+			})
+		}
+	}
+	entry.variants = append(entry.conditionalVariants(), variant)
+	g.router().setHandlers(map[routeKey]handlerChain{{method, pattern}: entry.handlerChain()})
+	return route
+}
+
+// Remove removes the route registered with the given pattern and method,
+// it reports whether the route exists. It is safe to call while http.Server
+// is serving http requests, requests in-flight will be finished by the
+// removed handler.
+func (g *routerGroup) Remove(method HTTPMethodType, pattern string) bool {
+	return g.RemoveRoute(methodName(method), pattern)
 }
 
-// Handle registers a new request handle with the given pattern and method.
-func (g *routerGroup) addRoute(method HTTPMethodType, pattern string, handler HandlerFunc) {
+// RemoveRoute is the same as Remove but accepts any method token,
+// i.e. RemoveRoute("PROPFIND", "/files/*path"). Names and errors of
+// replacing the route are removed as well.
+func (g *routerGroup) RemoveRoute(method string, pattern string) bool {
 	assert(len(pattern) > 0, "pattern cannot be empty")
 	assert(pattern[0] == '/', "pattern must begin with '/'")
-	assert(handler != nil, "handler must not be nil")
 
-	var methodString string
+	pattern = g.fullPattern(pattern)
+
+	e := g.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := routeID{g.host, method, pattern}
+	if entry, ok := e.routes[id]; ok {
+		for _, variant := range entry.variants {
+			e.removeConflict(variant.conflict)
+		}
+		delete(e.routes, id)
+	}
+	e.unnameRoute(id)
+	return g.router().removeRoute(method, pattern)
+}
+
+// fullPattern returns pattern with group prefix and without trailing slash.
+func (g *routerGroup) fullPattern(pattern string) string {
+	pattern = g.basePath + pattern
+
+	if len(pattern) > 1 && pattern[len(pattern)-1] == '/' {
+		pattern = pattern[:len(pattern)-1]
+	}
+	return pattern
+}
+
+// validMethod reports whether method is a valid method name, which is
+// a token defined by RFC 7230, i.e. "GET" or "PROPFIND".
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		c := method[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// methodName returns the name of http method, empty string if method is invalid.
+func methodName(method HTTPMethodType) string {
 	switch method {
 	case HTTP_GET:
-		methodString = http.MethodGet
+		return http.MethodGet
 	case HTTP_HEAD:
-		methodString = http.MethodHead
+		return http.MethodHead
 	case HTTP_POST:
-		methodString = http.MethodPost
+		return http.MethodPost
 	case HTTP_PUT:
-		methodString = http.MethodPut
+		return http.MethodPut
 	case HTTP_DELETE:
-		methodString = http.MethodDelete
+		return http.MethodDelete
 	case HTTP_TRACE:
-		methodString = http.MethodTrace
+		return http.MethodTrace
 	case HTTP_OPTIONS:
-		methodString = http.MethodOptions
+		return http.MethodOptions
 	case HTTP_CONNECT:
-		methodString = http.MethodConnect
+		return http.MethodConnect
 	case HTTP_PATCH:
-		methodString = http.MethodPatch
-	default:
-		log.Error("invalid method type, unable to add route: %s-%s", method, pattern)
-	}
-
-	pattern = g.basePath + pattern
-
-	if len(pattern) > 1 && pattern[len(pattern)-1] == '/' {
-		pattern = pattern[:len(pattern)-1]
+		return http.MethodPatch
 	}
-
-	g.router.addRoute(methodString, pattern, handler)
+	return ""
 }
 
 // Static serves static files from the given file system root
@@ -191,17 +537,19 @@ func (g *routerGroup) addRoute(method HTTPMethodType, pattern string, handler Ha
 // in "filepath".
 //
 // Use (*Context).GetRouteParam("filepath") to get the value.
-func (g *routerGroup) Static(pattern string, root string) {
+// Route middlewares can be given, i.e. Static("/static", "./assets", auth).
+func (g *routerGroup) Static(pattern string, root string, middlewares ...HandlerFunc) {
 	if strings.Contains(pattern, ":") || strings.Contains(pattern, "*") {
 		panic("URL parameters can not be used when serving a static folder")
 	}
 
-	handler := g.staticHandler(pattern, http.Dir(root))
+	handlers := append(handlerChain(nil), middlewares...)
+	handlers = append(handlers, g.staticHandler(pattern, http.Dir(root)))
 	cleanPattern := path.Join(pattern, "/*filepath")
 
 	// register handlers
-	g.GET(cleanPattern, handler)
-	g.HEAD(cleanPattern, handler)
+	g.GET(cleanPattern, handlers...)
+	g.HEAD(cleanPattern, handlers...)
 }
 
 func (g *routerGroup) staticHandler(pattern string, fs http.FileSystem) HandlerFunc {
@@ -221,3 +569,46 @@ func (g *routerGroup) staticHandler(pattern string, fs http.FileSystem) HandlerF
 		fileServer.ServeHTTP(c.ResponseWriter, c.Request)
 	}
 }
+
+// Mount serves requests of all methods to prefix and paths below by h,
+// prefix is stripped before passing the request to h, i.e. Mount("/legacy", h)
+// serves "/legacy/users" by h as "/users". h can be any http.Handler
+// including another Engine.
+//
+// Group middlewares run around h as other routes, route middlewares can
+// be given as well, i.e. Mount("/admin", admin, auth). The route returned
+// covers prefix and paths below, predicates added by (*Route).When apply
+// to both.
+func (g *routerGroup) Mount(prefix string, h http.Handler, middlewares ...HandlerFunc) *Route {
+	if strings.Contains(prefix, ":") || strings.Contains(prefix, "*") {
+		panic("URL parameters can not be used when mounting a handler")
+	}
+	assert(h != nil, "handler must not be nil")
+
+	prefix = cleanPrefix(prefix)
+	handlers := append(handlerChain(nil), middlewares...)
+	handlers = append(handlers, mountHandler(g.basePath+prefix, h))
+
+	// "/*mountpath" does not match prefix itself
+	route := g.Any(prefix+"/*mountpath", handlers...)
+	if prefix != "" {
+		route.prefix = g.Any(prefix, handlers...)
+	}
+	return route
+}
+
+// mountHandler passes the request to h with prefix stripped, path
+// is "/" if the request is sent to prefix itself.
+func mountHandler(prefix string, h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		r := new(http.Request)
+		*r = *c.Request
+		r.URL = new(url.URL)
+		*r.URL = *c.Request.URL
+		r.URL.Path = "/" + c.GetRouteParam("mountpath")
+		if rawPath := c.Request.URL.RawPath; rawPath != "" {
+			r.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(rawPath, prefix), "/")
+		}
+		h.ServeHTTP(c.ResponseWriter, r)
+	}
+}
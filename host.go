@@ -0,0 +1,173 @@
+package umeshu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hostRouter is the router of a host pattern, i.e. "admin.example.com"
+// or ":tenant.example.com". Each label of host pattern is either static
+// or parameter, parameters are stored in Context.RouteParams.
+type hostRouter struct {
+	pattern string
+	labels  []string
+	router  Router
+}
+
+// newHostRouter returns new hostRouter, port of pattern is ignored.
+func newHostRouter(pattern string) *hostRouter {
+	pattern = strings.ToLower(stripPort(pattern))
+	assert(len(pattern) > 0, "host cannot be empty")
+
+	labels := strings.Split(pattern, ".")
+	for _, label := range labels {
+		assert(len(label) > 0, "host label cannot be empty")
+		assert(label != ":", "host parameter must be named")
+	}
+
+	return &hostRouter{
+		pattern: pattern,
+		labels:  labels,
+		router:  NewRouter(),
+	}
+}
+
+// isStatic reports whether host pattern has no parameter.
+func (h *hostRouter) isStatic() bool {
+	return !strings.Contains(h.pattern, ":")
+}
+
+// match reports whether host matches the host pattern, parameters
+// matched are appended to params. params is restored if not matched.
+func (h *hostRouter) match(host string, params *Params) bool {
+	n := len(*params)
+	for i, label := range h.labels {
+		var part string
+		if i == len(h.labels)-1 {
+			part, host = host, ""
+			if strings.IndexByte(part, '.') >= 0 {
+				part = ""
+			}
+		} else if idx := strings.IndexByte(host, '.'); idx >= 0 {
+			part, host = host[:idx], host[idx+1:]
+		} else {
+			part = ""
+		}
+
+		switch {
+		case part == "":
+			*params = (*params)[:n]
+			return false
+		case label[0] == ':':
+			*params = append(*params, Param{Key: label[1:], Value: part})
+		case !strings.EqualFold(label, part):
+			*params = (*params)[:n]
+			return false
+		}
+	}
+	return true
+}
+
+// build returns the host with parameters replaced by values,
+// i.e. "acme.example.com" of ":tenant.example.com".
+func (h *hostRouter) build(values map[string]string) (string, error) {
+	if h.isStatic() {
+		return h.pattern, nil
+	}
+
+	labels := make([]string, len(h.labels))
+	for i, label := range h.labels {
+		if label[0] != ':' {
+			labels[i] = label
+			continue
+		}
+		value, ok := values[label[1:]]
+		if !ok || value == "" || strings.ContainsAny(value, "./:") {
+			return "", fmt.Errorf("invalid or missing host parameter %s", label[1:])
+		}
+		labels[i] = value
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// stripPort returns host without port.
+func stripPort(host string) string {
+	idx := strings.LastIndexByte(host, ':')
+	if idx < 0 || idx == len(host)-1 {
+		return host
+	}
+	// not a port if it is part of IPv6 address or host parameter
+	for i := idx + 1; i < len(host); i++ {
+		if host[i] < '0' || host[i] > '9' {
+			return host
+		}
+	}
+	return host[:idx]
+}
+
+// Host creates a router group for routes served under host only,
+// the group has its own route tree. Host parameters are supported,
+// i.e. ":tenant.example.com", use (*Context).GetRouteParam("tenant")
+// to get the value. Port of host is ignored.
+//
+// Static hosts are matched first, then hosts with parameters in the
+// order of creation. Requests which no host is matched are served by
+// routes not bound to any host.
+//
+// Middlewares attached to the engine apply to all hosts as the engine
+// is the parent of host group, middlewares attached to the host group
+// and its sub-groups apply to the host only.
+func (e *Engine) Host(host string) *routerGroup {
+	h := newHostRouter(host)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, group := range e.groups {
+		if group.host != nil && group.host.pattern == h.pattern && group.basePath == "" {
+			return group
+		}
+	}
+
+	// static hosts are placed before hosts with parameters,
+	// hosts are copied on write as they are read without lock
+	old := e.loadHosts()
+	idx := len(old)
+	if h.isStatic() {
+		for i, existing := range old {
+			if !existing.isStatic() {
+				idx = i
+				break
+			}
+		}
+	}
+	hosts := make([]*hostRouter, 0, len(old)+1)
+	hosts = append(hosts, old[:idx]...)
+	hosts = append(hosts, h)
+	hosts = append(hosts, old[idx:]...)
+	e.hosts.Store(hosts)
+
+	return e.addGroup("", h, e.routerGroup)
+}
+
+// loadHosts returns routers of hosts.
+func (e *Engine) loadHosts() []*hostRouter {
+	return e.hosts.Load().([]*hostRouter)
+}
+
+// routerFor returns the router serving the request, host parameters
+// are stored in c.RouteParams.
+func (e *Engine) routerFor(c *Context) Router {
+	hosts := e.loadHosts()
+	if len(hosts) == 0 {
+		return e.router
+	}
+
+	host := stripPort(c.Request.Host)
+	for _, h := range hosts {
+		if h.match(host, &c.RouteParams) {
+			return h.router
+		}
+	}
+	return e.router
+}
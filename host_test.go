@@ -0,0 +1,78 @@
+package umeshu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEngine_Host(t *testing.T) {
+	e := New()
+	e.Use(func(c *Context) {
+		c.SetHeader("X-Engine", "1")
+		c.Next()
+	})
+	e.GET("/", func(c *Context) { c.String(http.StatusOK, "default") })
+	api := e.Group("/api")
+	api.Use(func(c *Context) {
+		c.SetHeader("X-Api", "default")
+		c.Next()
+	})
+	api.GET("/users", func(c *Context) { c.String(http.StatusOK, "default users") })
+
+	admin := e.Host("admin.example.com")
+	admin.Use(func(c *Context) {
+		c.SetHeader("X-Admin", "1")
+		c.Next()
+	})
+	admin.GET("/", func(c *Context) { c.String(http.StatusOK, "admin") })
+
+	tenant := e.Host(":tenant.example.com")
+	tenantAPI := tenant.SubGroup("/api")
+	tenantAPI.Use(func(c *Context) {
+		c.SetHeader("X-Api", "tenant")
+		c.Next()
+	})
+	tenantAPI.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "%s user %s", c.GetRouteParam("tenant"), c.GetRouteParam("id"))
+	})
+
+	if e.Host("ADMIN.example.com:8080") != admin {
+		t.Errorf("Host should return the existing group of the same host")
+	}
+
+	e.ApplyMiddleware()
+
+	tests := []struct {
+		host    string
+		path    string
+		code    int
+		body    string
+		headers map[string]string
+	}{
+		{"example.com", "/", http.StatusOK, "default", map[string]string{"X-Engine": "1", "X-Admin": ""}},
+		{"example.com", "/api/users", http.StatusOK, "default users", map[string]string{"X-Api": "default"}},
+		{"admin.example.com", "/", http.StatusOK, "admin", map[string]string{"X-Engine": "1", "X-Admin": "1"}},
+		{"Admin.Example.com:8080", "/", http.StatusOK, "admin", nil},
+		{"admin.example.com", "/api/users", http.StatusNotFound, "404 404 NOT FOUND: /api/users\n", nil},
+		{"acme.example.com", "/api/users/42", http.StatusOK, "acme user 42", map[string]string{"X-Engine": "1", "X-Api": "tenant", "X-Admin": ""}},
+		{"acme.example.com", "/", http.StatusNotFound, "404 404 NOT FOUND: /\n", nil},
+		{"a.b.example.com", "/", http.StatusOK, "default", nil},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, r)
+
+		if rw.Code != tt.code || rw.Body.String() != tt.body {
+			t.Errorf("%s%s = (%d, %q), want (%d, %q)", tt.host, tt.path, rw.Code, rw.Body.String(), tt.code, tt.body)
+		}
+		for key, value := range tt.headers {
+			if got := rw.Header().Get(key); got != value {
+				t.Errorf("%s%s: %s = %q, want %q", tt.host, tt.path, key, got, value)
+			}
+		}
+	}
+}
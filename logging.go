@@ -11,6 +11,6 @@ func Logging() HandlerFunc {
 	return func(c *Context) {
 		t := time.Now()
 		c.Next()
-		defer log.Info("[Umeshu] %v | %d | %s %s", time.Since(t), c.StatusCode, c.Method, c.Path)
+		log.Info("[Umeshu] %v | %d | %s %s", time.Since(t), c.Writer.Status(), c.Method, c.Path)
 	}
 }
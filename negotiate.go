@@ -0,0 +1,130 @@
+package umeshu
+
+import (
+	"mime"
+	"strings"
+)
+
+// Media types offered by (*Context).Negotiate.
+const (
+	MIMEJSON  = "application/json"
+	MIMEXML   = "application/xml"
+	MIMEXML2  = "text/xml"
+	MIMEHTML  = "text/html"
+	MIMEPlain = "text/plain"
+)
+
+// Offers are the responses offered by (*Context).Negotiate, nil or empty
+// ones are not offered.
+type Offers struct {
+	JSON     interface{} // object responsed by (*Context).JSON
+	XML      interface{} // object responsed by (*Context).XML
+	HTML     string      // name of template responsed by (*Context).HTMLTemplate
+	HTMLData interface{} // data of HTML template
+	Text     string      // plain text
+
+	// custom formats offered by their content type after the above,
+	// i.e. "text/csv"
+	Renderers []Renderer
+}
+
+// Negotiate responses http request by the offer which the request
+// accepts most according to "Accept" header with quality values, the
+// former one of JSON, XML, HTML, Text and Renderers is preferred if they
+// are equally accepted. HTTP406Handler is called if none is accepted.
+func (c *Context) Negotiate(code int, offers Offers) {
+	var offered []string
+	if offers.JSON != nil {
+		offered = append(offered, MIMEJSON)
+	}
+	if offers.XML != nil {
+		offered = append(offered, MIMEXML, MIMEXML2)
+	}
+	if offers.HTML != "" {
+		offered = append(offered, MIMEHTML)
+	}
+	if offers.Text != "" {
+		offered = append(offered, MIMEPlain)
+	}
+	renderers := make(map[string]Renderer, len(offers.Renderers))
+	for _, r := range offers.Renderers {
+		mediaType, _, err := mime.ParseMediaType(r.ContentType())
+		if err != nil || containsString(offered, mediaType) {
+			continue
+		}
+		renderers[mediaType] = r
+		offered = append(offered, mediaType)
+	}
+
+	c.ResponseWriter.Header().Add("Vary", "Accept")
+	format := c.NegotiateFormat(offered...)
+	if r, ok := renderers[format]; ok {
+		c.Render(code, r)
+		return
+	}
+
+	switch format {
+	case MIMEJSON:
+		c.JSON(code, offers.JSON)
+	case MIMEXML, MIMEXML2:
+		c.XML(code, offers.XML)
+	case MIMEHTML:
+		c.HTMLTemplate(code, offers.HTML, offers.HTMLData)
+	case MIMEPlain:
+		c.String(code, "%s", offers.Text)
+	default:
+		HTTP406Handler(c)
+	}
+}
+
+// NegotiateFormat returns the offered media type which the request
+// accepts most according to "Accept" header, the former one is preferred
+// if they are equally accepted. It returns the first one if "Accept"
+// header is not given and empty string if none is accepted.
+func (c *Context) NegotiateFormat(offered ...string) string {
+	accept := c.Request.Header.Values("Accept")
+	if len(accept) == 0 {
+		if len(offered) == 0 {
+			return ""
+		}
+		return offered[0]
+	}
+
+	var ranges []string
+	for _, value := range accept {
+		ranges = append(ranges, strings.Split(value, ",")...)
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offered {
+		if q := acceptQuality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality value of the most specific media
+// range matching mediaType, 0 if none matches.
+func acceptQuality(ranges []string, mediaType string) float64 {
+	mediaType = strings.ToLower(mediaType)
+	slash := strings.IndexByte(mediaType, '/')
+
+	q, specificity := 0.0, -1
+	for _, mediaRange := range ranges {
+		r, rq := parseMediaRange(mediaRange)
+		s := -1
+		switch {
+		case r == mediaType:
+			s = 2
+		case slash >= 0 && r == mediaType[:slash]+"/*":
+			s = 1
+		case r == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = rq, s
+		}
+	}
+	return q
+}
@@ -0,0 +1,51 @@
+package umeshu
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEngine_Negotiate(t *testing.T) {
+	type user struct {
+		Name string `json:"name" xml:"name"`
+	}
+
+	e := New()
+	e.GET("/user", func(c *Context) {
+		c.Negotiate(http.StatusOK, Offers{JSON: user{"alice"}, XML: user{"alice"}, Text: "alice"})
+	})
+	e.GET("/json", func(c *Context) {
+		c.Negotiate(http.StatusOK, Offers{JSON: user{"alice"}})
+	})
+
+	tests := []struct {
+		path   string
+		accept string
+		code   int
+		body   string
+	}{
+		{"/user", "", http.StatusOK, "{\"name\":\"alice\"}\n"},
+		{"/user", "*/*", http.StatusOK, "{\"name\":\"alice\"}\n"},
+		{"/user", "application/xml", http.StatusOK, xml.Header + "<user><name>alice</name></user>"},
+		{"/user", "application/json;q=0.5, text/*;q=0.8", http.StatusOK, "<?xml"},
+		{"/user", "application/json;q=0.5, text/plain;q=0.8, text/*;q=0.1", http.StatusOK, "alice"},
+		{"/user", "text/html, */*;q=0.1", http.StatusOK, "{\"name\":\"alice\"}\n"},
+		{"/json", "text/html", http.StatusNotAcceptable, "406 406 NOT ACCEPTABLE: GET /json\n"},
+		{"/json", "application/*;q=0, */*", http.StatusNotAcceptable, "406 406 NOT ACCEPTABLE: GET /json\n"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, r)
+		if rw.Code != tt.code || !strings.HasPrefix(rw.Body.String(), tt.body) {
+			t.Errorf("get %s accept %q: (%d, %q), want (%d, %q)", tt.path, tt.accept, rw.Code, rw.Body.String(), tt.code, tt.body)
+		}
+	}
+}
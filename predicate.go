@@ -0,0 +1,204 @@
+package umeshu
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Predicate reports whether the request should be handled by the route,
+// see (*Route).When.
+type Predicate func(c *Context) bool
+
+// When registers the route with predicate, so that handlers of the same
+// method and pattern can be chosen by the request, i.e. by headers.
+//
+// Routes with predicates are tried in the order of registration, the
+// first one which all predicates are satisfied handles the request. The
+// route without predicate is the default one, it handles the request if
+// no other route matches, otherwise 406 is responsed by HTTP406Handler.
+//
+// The route becomes a conditional one and the default route it replaced
+// on registration, if any, is restored. When can be called more than
+// once, all predicates must be satisfied.
+func (r *Route) When(predicate Predicate) *Route {
+	return r.when(nameOfFunction(predicate), "", predicate)
+}
+
+// Accepts registers the route for requests accepting any of the media
+// types, i.e. GET("/users", handler).Accepts("application/vnd.acme.v2+json").
+// Media ranges with wildcard, i.e. "*/*", do not match, so such requests
+// are handled by the default route. See (*Route).When for details.
+func (r *Route) Accepts(mediaTypes ...string) *Route {
+	assert(len(mediaTypes) > 0, "media type must be given")
+	return r.when("Accept: "+strings.Join(mediaTypes, ", "), "Accept", func(c *Context) bool {
+		return acceptsMediaType(c.Request.Header.Values("Accept"), mediaTypes)
+	})
+}
+
+// Header registers the route for requests with the header value,
+// i.e. GET("/users", handler).Header("X-API-Version", "2").
+// See (*Route).When for details.
+func (r *Route) Header(key string, value string) *Route {
+	key = http.CanonicalHeaderKey(key)
+	return r.when(key+": "+value, key, func(c *Context) bool {
+		return c.Request.Header.Get(key) == value
+	})
+}
+
+// when adds predicate to the variants registered by the route, vary is
+// the header the predicate depends on, empty if unknown.
+func (r *Route) when(condition string, vary string, predicate Predicate) *Route {
+	assert(predicate != nil, "predicate must not be nil")
+	if r.prefix != nil {
+		r.prefix.when(condition, vary, predicate)
+	}
+
+	e := r.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, method := range r.Methods {
+		variant := r.variants[i]
+		id := routeID{r.group.host, method, r.Pattern}
+		route, ok := e.routes[id]
+		if !ok || !route.contains(variant) {
+			// removed or replaced since registration
+			continue
+		}
+
+		variants := route.conditionalVariants()
+		if variant.predicates == nil {
+			// the default variant becomes a conditional one
+			variants = append(variants, variant)
+			if variant.replaced != nil {
+				variants = append(variants, variant.replaced)
+				if variant.replaced.conflict == nil {
+					// still reported if the restored one replaced others
+					e.removeConflict(variant.conflict)
+				}
+				variant.replaced, variant.conflict = nil, nil
+			}
+		} else {
+			variants = route.variants
+		}
+
+		// copy on write as variants are read by dispatchHandler
+		updated := *variant
+		updated.predicates = append(append([]Predicate(nil), variant.predicates...), predicate)
+		updated.conditions = append(append([]string(nil), variant.conditions...), condition)
+		if vary != "" {
+			updated.vary = append(append([]string(nil), variant.vary...), vary)
+		}
+		for j := range variants {
+			if variants[j] == variant {
+				variants[j] = &updated
+			}
+		}
+		r.variants[i] = &updated
+
+		route.variants = variants
+		e.routerOf(id).setHandlers(map[routeKey]handlerChain{{method, r.Pattern}: route.handlerChain()})
+	}
+	return r
+}
+
+// contains reports whether variant is a variant of the route.
+func (r *routeEntry) contains(variant *routeVariant) bool {
+	for _, v := range r.variants {
+		if v == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether all predicates of the variant are satisfied.
+func (v *routeVariant) match(c *Context) bool {
+	for _, predicate := range v.predicates {
+		if !predicate(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatchHandler returns a HandlerFunc running handlerChain of the first
+// variant matching the request, HTTP406Handler if not any, the first
+// shared handlers of the chains have run already. "Vary" header is set
+// by the headers predicates depend on. (*Engine).mu must be held.
+func dispatchHandler(variants []*routeVariant, shared int) HandlerFunc {
+	chains := make([]handlerChain, len(variants))
+	var vary []string
+	for i, variant := range variants {
+		chains[i] = variant.handlerChain()[shared:]
+		for _, header := range variant.vary {
+			if !containsString(vary, header) {
+				vary = append(vary, header)
+			}
+		}
+	}
+	variants = append([]*routeVariant(nil), variants...)
+
+	return func(c *Context) {
+		for _, header := range vary {
+			c.ResponseWriter.Header().Add("Vary", header)
+		}
+		for i, variant := range variants {
+			if variant.match(c) {
+				// handlers of the variant run next, the chain of the
+				// route is shared by requests and must not be modified
+				c.handlers = append(c.handlers[:c.index:c.index], chains[i]...)
+				return
+			}
+		}
+		HTTP406Handler(c)
+	}
+}
+
+// acceptsMediaType reports whether any of the media types is accepted
+// by Accept header values explicitly, i.e. not by wildcard.
+func acceptsMediaType(accept []string, mediaTypes []string) bool {
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, q := parseMediaRange(mediaRange)
+			if q == 0 {
+				continue
+			}
+			for _, t := range mediaTypes {
+				if strings.EqualFold(mediaType, t) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseMediaRange parses a media range of Accept header into media
+// type in lower case and its quality value, i.e. "text/html;q=0.8"
+// into "text/html" and 0.8. Quality value is 1 if it is not given.
+func parseMediaRange(mediaRange string) (mediaType string, q float64) {
+	q = 1
+	parts := strings.Split(mediaRange, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(parts[0]))
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+			if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = value
+			}
+		}
+	}
+	return mediaType, q
+}
+
+// containsString reports whether s is in list.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
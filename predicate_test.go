@@ -0,0 +1,128 @@
+package umeshu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEngine_predicates(t *testing.T) {
+	e := New()
+	v1 := func(c *Context) { c.String(http.StatusOK, "v1") }
+	v2 := func(c *Context) { c.String(http.StatusOK, "v2") }
+	v3 := func(c *Context) { c.String(http.StatusOK, "v3") }
+
+	// default registered first
+	e.GET("/users", v1)
+	e.GET("/users", v2).Accepts("application/vnd.acme.v2+json")
+	e.GET("/users", v3).Header("X-API-Version", "3")
+
+	// default registered last
+	e.GET("/posts", v2).Accepts("application/vnd.acme.v2+json")
+	e.GET("/posts", v1)
+
+	// no default
+	g := e.Group("/api")
+	g.Use(func(c *Context) {
+		c.ResponseWriter.Header().Set("X-Group", "api")
+		c.Next()
+	})
+	g.GET("/items", v2).Accepts("application/vnd.acme.v2+json")
+
+	if err := e.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	tests := []struct {
+		path   string
+		header map[string]string
+		code   int
+		body   string
+	}{
+		{"/users", nil, http.StatusOK, "v1"},
+		{"/users", map[string]string{"Accept": "*/*"}, http.StatusOK, "v1"},
+		{"/users", map[string]string{"Accept": "application/vnd.acme.v2+json"}, http.StatusOK, "v2"},
+		{"/users", map[string]string{"Accept": "text/html, Application/Vnd.Acme.V2+JSON;q=0.9"}, http.StatusOK, "v2"},
+		{"/users", map[string]string{"Accept": "application/vnd.acme.v2+json;q=0"}, http.StatusOK, "v1"},
+		{"/users", map[string]string{"X-API-Version": "3"}, http.StatusOK, "v3"},
+		{"/posts", nil, http.StatusOK, "v1"},
+		{"/posts", map[string]string{"Accept": "application/vnd.acme.v2+json"}, http.StatusOK, "v2"},
+		{"/api/items", map[string]string{"Accept": "application/vnd.acme.v2+json"}, http.StatusOK, "v2"},
+		{"/api/items", map[string]string{"Accept": "application/json"}, http.StatusNotAcceptable, "406 406 NOT ACCEPTABLE: GET /api/items\n"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for key, value := range tt.header {
+			r.Header.Set(key, value)
+		}
+		e.ServeHTTP(rw, r)
+		if rw.Code != tt.code || rw.Body.String() != tt.body {
+			t.Errorf("get %s %v: (%d, %q), want (%d, %q)", tt.path, tt.header, rw.Code, rw.Body.String(), tt.code, tt.body)
+		}
+		if tt.path == "/api/items" && rw.Header().Get("X-Group") != "api" {
+			t.Errorf("get %s %v: group middleware not run", tt.path, tt.header)
+		}
+		if vary := rw.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("get %s %v: Vary = %q, want Accept", tt.path, tt.header, vary)
+		}
+	}
+
+	var conditions [][]string
+	for _, route := range e.Routes() {
+		if route.Pattern == "/users" {
+			conditions = append(conditions, route.Conditions)
+		}
+	}
+	want := [][]string{{"Accept: application/vnd.acme.v2+json"}, {"X-Api-Version: 3"}, nil}
+	if !reflect.DeepEqual(conditions, want) {
+		t.Errorf("conditions of /users = %v, want %v", conditions, want)
+	}
+}
+
+func TestEngine_predicatesInsideMiddlewares(t *testing.T) {
+	e := New()
+	e.Use(Recovery(), chainTestMiddleware("engine"))
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+
+	api := e.Group("/api")
+	api.Use(chainTestMiddleware("api"))
+	api.GET("/users", chainTestMiddleware("route"), ok).Accepts("application/vnd.acme.v2+json")
+	api.GET("/panic", ok).When(func(c *Context) bool { panic("predicate") })
+
+	// variants of sub-groups share middlewares of their common group
+	v2 := api.SubGroup("/v2")
+	v2.Use(chainTestMiddleware("v2"))
+	v2.GET("/items", ok).Header("X-API-Version", "2")
+	api.GET("/v2/items", ok)
+
+	tests := []struct {
+		path   string
+		header map[string]string
+		code   int
+		chain  []string
+	}{
+		{"/api/users", map[string]string{"Accept": "application/vnd.acme.v2+json"}, http.StatusOK, []string{"engine", "api", "route"}},
+		{"/api/users", map[string]string{"Accept": "application/json"}, http.StatusNotAcceptable, []string{"engine", "api"}},
+		{"/api/panic", nil, http.StatusInternalServerError, []string{"engine", "api"}},
+		{"/api/v2/items", map[string]string{"X-API-Version": "2"}, http.StatusOK, []string{"engine", "api", "v2"}},
+		{"/api/v2/items", nil, http.StatusOK, []string{"engine", "api"}},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for key, value := range tt.header {
+			r.Header.Set(key, value)
+		}
+		e.ServeHTTP(rw, r)
+		if rw.Code != tt.code {
+			t.Errorf("get %s %v: status = %d, want %d", tt.path, tt.header, rw.Code, tt.code)
+		}
+		if chain := rw.Header()["X-Chain"]; !reflect.DeepEqual(chain, tt.chain) {
+			t.Errorf("get %s %v: X-Chain = %v, want %v", tt.path, tt.header, chain, tt.chain)
+		}
+	}
+}
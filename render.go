@@ -0,0 +1,69 @@
+package umeshu
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/knchan0x/umeshu/log"
+)
+
+// Renderer renders response body in its format, i.e. CSV or MessagePack,
+// see (*Context).Render.
+type Renderer interface {
+	// ContentType returns the value of "Content-Type" header,
+	// i.e. "text/csv; charset=utf-8"
+	ContentType() string
+
+	// Render writes response body to w
+	Render(w io.Writer) error
+}
+
+// bufPool is the buffer pool for rendering response body.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Render responses http request by the body rendered by r. The body is
+// buffered before writing to the response, so that HTTP500Handler is
+// called with nothing written if r fails to render.
+func (c *Context) Render(code int, r Renderer) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
+	if err := r.Render(buf); err != nil {
+		log.Error("unable to render response: %s", err)
+		HTTP500Handler(c)
+		return
+	}
+
+	if contentType := r.ContentType(); contentType != "" {
+		c.SetHeader("Content-Type", contentType)
+	}
+	c.Data(code, buf.Bytes())
+}
+
+// funcRenderer is a Renderer rendering body by function,
+// it is used by response helpers of Context.
+type funcRenderer struct {
+	contentType string
+	render      func(w io.Writer) error
+}
+
+// renderFunc returns Renderer of content type rendering body by render.
+func renderFunc(contentType string, render func(w io.Writer) error) Renderer {
+	return funcRenderer{contentType, render}
+}
+
+// ContentType returns the value of "Content-Type" header.
+func (r funcRenderer) ContentType() string {
+	return r.contentType
+}
+
+// Render writes response body to w.
+func (r funcRenderer) Render(w io.Writer) error {
+	return r.render(w)
+}
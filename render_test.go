@@ -0,0 +1,119 @@
+package umeshu
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContext_renderers(t *testing.T) {
+	object := JSONData{"name": "日本<b>"}
+
+	tests := []struct {
+		name        string
+		path        string
+		render      func(c *Context)
+		code        int
+		contentType string
+		body        string
+	}{
+		{"IndentedJSON", "/", func(c *Context) { c.IndentedJSON(http.StatusOK, object) },
+			http.StatusOK, "application/json", "{\n    \"name\": \"日本\\u003cb\\u003e\"\n}"},
+		{"SecureJSON", "/", func(c *Context) { c.SecureJSON(http.StatusOK, []int{1, 2}) },
+			http.StatusOK, "application/json", "while(1);[1,2]"},
+		{"AsciiJSON", "/", func(c *Context) { c.AsciiJSON(http.StatusOK, JSONData{"name": "日本😀"}) },
+			http.StatusOK, "application/json", `{"name":"\u65e5\u672c\ud83d\ude00"}`},
+		{"JSONP", "/?callback=app.cb_1", func(c *Context) { c.JSONP(http.StatusOK, []int{1}) },
+			http.StatusOK, "application/javascript", "/**/app.cb_1([1]);"},
+		{"JSONP without callback", "/", func(c *Context) { c.JSONP(http.StatusOK, []int{1}) },
+			http.StatusOK, "application/json", "[1]\n"},
+		{"JSONP invalid callback", "/?callback=alert(1)//", func(c *Context) { c.JSONP(http.StatusOK, []int{1}) },
+			http.StatusBadRequest, "text/plain", "400 invalid JSONP callback"},
+		{"NDJSON", "/", func(c *Context) { c.NDJSON(http.StatusOK, []JSONData{{"id": 1}, {"id": 2}}) },
+			http.StatusOK, "application/x-ndjson", "{\"id\":1}\n{\"id\":2}\n"},
+		{"XML", "/", func(c *Context) { c.XML(http.StatusCreated, []string{"a"}) },
+			http.StatusCreated, "application/xml", xml.Header + "<string>a</string>"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		c := NewContext(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		tt.render(c)
+		c.Free()
+		if rw.Code != tt.code || rw.Header().Get("Content-Type") != tt.contentType || rw.Body.String() != tt.body {
+			t.Errorf("%s: (%d, %q, %q), want (%d, %q, %q)", tt.name,
+				rw.Code, rw.Header().Get("Content-Type"), rw.Body.String(), tt.code, tt.contentType, tt.body)
+		}
+	}
+}
+
+// csvTestRenderer renders records as CSV.
+
+type csvTestRenderer [][]string
+
+func (r csvTestRenderer) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (r csvTestRenderer) Render(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.WriteAll(r)
+	return cw.Error()
+}
+
+// failTestRenderer writes partial body and fails.
+type failTestRenderer struct{}
+
+func (failTestRenderer) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (failTestRenderer) Render(w io.Writer) error {
+	w.Write([]byte("partial"))
+	return fmt.Errorf("encode error")
+}
+
+func TestContext_Render(t *testing.T) {
+	records := csvTestRenderer{{"id", "name"}, {"1", "alice"}}
+
+	tests := []struct {
+		name        string
+		accept      string
+		render      func(c *Context)
+		code        int
+		contentType string
+		body        string
+	}{
+		{"custom", "", func(c *Context) { c.Render(http.StatusOK, records) },
+			http.StatusOK, "text/csv; charset=utf-8", "id,name\n1,alice\n"},
+		{"failed", "", func(c *Context) { c.Render(http.StatusOK, failTestRenderer{}) },
+			http.StatusInternalServerError, "text/plain", "500 Internal Server Error"},
+		{"failed JSON", "", func(c *Context) { c.JSON(http.StatusOK, JSONData{"ch": make(chan int)}) },
+			http.StatusInternalServerError, "text/plain", "500 Internal Server Error"},
+		{"negotiate custom", "text/csv", func(c *Context) {
+			c.Negotiate(http.StatusOK, Offers{JSON: records, Renderers: []Renderer{records}})
+		}, http.StatusOK, "text/csv; charset=utf-8", "id,name\n1,alice\n"},
+		{"negotiate builtin", "*/*", func(c *Context) {
+			c.Negotiate(http.StatusOK, Offers{Text: "records", Renderers: []Renderer{records}})
+		}, http.StatusOK, "text/plain", "records"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		c := NewContext(rw, r)
+		tt.render(c)
+		c.Free()
+		if rw.Code != tt.code || rw.Header().Get("Content-Type") != tt.contentType || rw.Body.String() != tt.body {
+			t.Errorf("%s: (%d, %q, %q), want (%d, %q, %q)", tt.name,
+				rw.Code, rw.Header().Get("Content-Type"), rw.Body.String(), tt.code, tt.contentType, tt.body)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package umeshu
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/knchan0x/umeshu/log"
+)
+
+// ResponseWriter wraps http.ResponseWriter and records the status code
+// and size of the response, so that middlewares such as Logging can
+// report them no matter how handlers write the response. Flusher,
+// Hijacker, Pusher and CloseNotifier of the wrapped one keep working.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	http.CloseNotifier
+
+	// Status returns the status code of the response, 200 if the body
+	// is written without calling WriteHeader or nothing is written yet
+	Status() int
+
+	// Size returns bytes of the response body written
+	Size() int
+
+	// Written reports whether the response header has been written
+	Written() bool
+
+	// Unwrap returns the wrapped http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// responseWriter is the default implementation of ResponseWriter,
+// it is kept in Context for re-use.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	size    int
+	written bool
+}
+
+var _ ResponseWriter = (*responseWriter)(nil) // interface check
+
+// reset wraps rw and clears the recorded status and size.
+func (w *responseWriter) reset(rw http.ResponseWriter) {
+	w.ResponseWriter = rw
+	w.status = http.StatusOK
+	w.size = 0
+	w.written = false
+}
+
+// WriteHeader records the status code and writes the response header.
+// Informational status codes, except 101, can be written before the
+// final one, otherwise it is ignored if the header has been written.
+func (w *responseWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.written {
+		log.Warning("response header has already been written with %d, unable to write %d", w.status, code)
+		return
+	}
+	w.status = code
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes data to the response body, the header is written with
+// 200 if it has not been written.
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// WriteString writes s to the response body, see (*responseWriter).Write.
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Status returns the status code of the response.
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// Size returns bytes of the response body written.
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+// Written reports whether the response header has been written.
+func (w *responseWriter) Written() bool {
+	return w.written
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends buffered data to the client, the header is written with
+// 200 if it has not been written. It does nothing if the wrapped one
+// is not a http.Flusher.
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.written = true
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, the response is
+// regarded as written. It returns error if the wrapped one is not a
+// http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// Push initiates HTTP/2 server push, it returns http.ErrNotSupported
+// if the wrapped one is not a http.Pusher.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// CloseNotify returns a channel receiving a value when the client
+// connection has gone away, the channel never receives if the wrapped
+// one is not a http.CloseNotifier.
+//
+// Deprecated: use Context of the request instead, as http.CloseNotifier.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
@@ -0,0 +1,71 @@
+package umeshu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEngine_ResponseWriter(t *testing.T) {
+	type record struct {
+		status  int
+		size    int
+		written bool
+	}
+
+	var got record
+	e := New()
+	e.HandleHEAD = true
+	e.Use(func(c *Context) {
+		c.Next()
+		got = record{c.Writer.Status(), c.Writer.Size(), c.Writer.Written()}
+	})
+	e.GET("/redirect", func(c *Context) { c.Redirect(http.StatusFound, "/users") })
+	e.GET("/wrapped", WrapF(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write([]byte("accepted"))
+	}))
+	e.GET("/implicit", WrapF(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	e.GET("/twice", func(c *Context) {
+		c.Writer.WriteHeader(http.StatusCreated)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+	})
+	e.GET("/flush", func(c *Context) {
+		c.Writer.Flush()
+		if err := c.Writer.Push("/app.js", nil); err != http.ErrNotSupported {
+			t.Errorf("Push() = %v, want http.ErrNotSupported", err)
+		}
+		if _, _, err := c.Writer.Hijack(); err == nil {
+			t.Errorf("Hijack() should return error")
+		}
+	})
+	e.GET("/empty", func(c *Context) {})
+
+	tests := []struct {
+		method string
+		path   string
+		want   record
+	}{
+		{http.MethodGet, "/redirect", record{http.StatusFound, len("<a href=\"/users\">Found</a>.\n\n"), true}},
+		{http.MethodGet, "/wrapped", record{http.StatusAccepted, len("accepted"), true}},
+		{http.MethodGet, "/implicit", record{http.StatusOK, len("ok"), true}},
+		{http.MethodHead, "/implicit", record{http.StatusOK, len("ok"), true}},
+		{http.MethodGet, "/twice", record{http.StatusCreated, 0, true}},
+		{http.MethodGet, "/flush", record{http.StatusOK, 0, true}},
+		{http.MethodGet, "/empty", record{http.StatusOK, 0, false}},
+	}
+
+	for _, tt := range tests {
+		got = record{}
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if got != tt.want {
+			t.Errorf("%s %s: (status, size, written) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+		if got.written && rw.Code != got.status {
+			t.Errorf("%s %s: code = %d, want %d", tt.method, tt.path, rw.Code, got.status)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package umeshu
 
 import (
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/knchan0x/umeshu/container"
 	"github.com/knchan0x/umeshu/log"
@@ -11,127 +16,261 @@ import (
 
 // Router is a multiplexer. It registers, directs and handles url path.
 type Router interface {
-	// addRoute registers pattern and handler
-	addRoute(method string, pattern string, handlers ...HandlerFunc)
+	// addRoute registers pattern and handler, it returns error if
+	// pattern is invalid or conflicts with registered patterns
+	addRoute(method string, pattern string, handlers ...HandlerFunc) error
 
 	// getRoute find registered pattern according to the http request,
-	// it also returns route parameters parsed
-	getRoute(method string, path string) (registeredPath string, params map[string]string)
+	// route parameters parsed are appended to params
+	getRoute(method string, path string, params *Params) (registeredPath string)
 
-	// applyMiddlewares adds middlewares to the handlerChain
-	applyMiddlewares(method string, path string, handlers handlerChain)
+	// removeRoute removes registered pattern and handler,
+	// it reports whether the route exists
+	removeRoute(method string, pattern string) bool
 
-	// allRoutes returns a slice of  all registered routes' RouteInfo
-	allRoutes() []RouteInfo
+	// setHandlers replaces handlerChains of registered routes at once,
+	// unregistered ones are ignored, it returns number of routes replaced
+	setHandlers(handlers map[routeKey]handlerChain) int
+
+	// setDefaults replaces default values of optional parameters of
+	// registered pattern, it reports whether the route exists
+	setDefaults(method string, pattern string, defaults Params) bool
 
 	// handle handles the http request
 	handle(*Context)
 }
 
+// Param is a single route parameter, consisting of a key and a value.
+type Param = container.Param
+
+// Params is a slice of Param, stored in Context.RouteParams.
+type Params = container.Params
+
 // RouteInfo contains information of a registered route like method and pattern.
 type RouteInfo struct {
-	Method  string
-	Pattern string
+	Method      string   `json:"method"`
+	Pattern     string   `json:"pattern"`
+	Host        string   `json:"host,omitempty"`       // empty if not bound to any host
+	Name        string   `json:"name,omitempty"`       // name given by (*Route).Name
+	Handler     string   `json:"handler"`              // function name of handler
+	Middlewares []string `json:"middlewares"`          // function names of middlewares, in order
+	Conditions  []string `json:"conditions,omitempty"` // predicates of the route, see (*Route).When
 }
 
-// Default implementation of Router interface. It is thread-safe unless registering
-// route after http.Server starts listening and serving http requests.
+// Default implementation of Router interface. It is thread-safe, routes
+// can be registered, replaced and removed while http.Server is serving
+// http requests.
+//
+// Registered routes are kept in an immutable routerState. Registration
+// copies the state, modifies the copy and swaps it atomically, so that
+// lookups are lock-free and in-flight requests finish on the old state.
 type router struct {
+	mu    sync.Mutex   // serializes registration
+	state atomic.Value // *routerState
+}
+
+// routerState is a snapshot of registered routes, it must not be
+// modified once stored in router.
+type routerState struct {
 	// method trees
 	// different tree for different http methods
 	trees map[string]*routerNode
 
-	// map registered pattern with handler
-	handlers map[string]handlerChain
+	// map registered pattern with handler and default values
+	routes routeTable
+}
+
+// routeKey is the key of registered handlers.
+type routeKey struct {
+	method  string
+	pattern string
 }
 
 var _ Router = (*router)(nil) // interface check
 
 // NewRouter returns an new router instance
 func NewRouter() Router {
-	router := &router{
-		trees:    make(map[string]*routerNode),
-		handlers: make(map[string]handlerChain),
-	}
+	router := &router{}
+	router.state.Store(&routerState{
+		trees: make(map[string]*routerNode),
+	})
 	return router
 }
 
-// SetRouter sets the global router.
-func SetRouter(router Router) {
-	GlobalRouter = router
+// load returns current snapshot of registered routes.
+func (r *router) load() *routerState {
+	return r.state.Load().(*routerState)
 }
 
-// addRoute adds pattern and handler to relvent method tree.
-func (r *router) addRoute(method string, pattern string, handlers ...HandlerFunc) {
-	if _, ok := r.trees[method]; !ok {
+// update copies current state, passes the copy to fn for modification
+// and stores it, the copy is discarded if fn returns error. The tree of
+// method is copied as well, nodes of the tree are copied by
+// container.RadixNode on modification, and routes by routeTable. No tree
+// is copied if method is empty, fn must not modify trees then.
+func (r *router) update(method string, fn func(s *routerState) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.load()
+	s := &routerState{
+		trees:  make(map[string]*routerNode, len(old.trees)+1),
+		routes: old.routes,
+	}
+	for m, root := range old.trees {
+		s.trees[m] = root
+	}
+
+	if root, ok := s.trees[method]; ok {
+		s.trees[method] = root.Clone()
+	} else if method != "" {
 		// use new(Node) will create a dummy head node
 		// and will cause mismatch in levels when searching
-		r.trees[method] = NewRootNode()
+		s.trees[method] = NewRootNode()
 	}
-	r.trees[method].Insert(pattern)
 
-	fullPath := method + "-" + pattern
-	r.handlers[fullPath] = handlers
-	log.Info("Route: %s-%s", method, pattern)
+	if err := fn(s); err != nil {
+		return err
+	}
+	r.state.Store(s)
+	return nil
 }
 
-// getRoute find registered pattern according to the path,
-// it also returns route parameters parsed.
-func (r *router) getRoute(method string, path string) (string, map[string]string) {
-	// check is http method registered
-	if root, ok := r.trees[method]; ok {
-		// check is route exists
-		if registeredPath := root.Find(path); registeredPath != "" {
-			parts := parsePattern(path)
-			keys := parsePattern(registeredPath)
-			params := matchParams(keys, parts)
-			return registeredPath, params
+// addRoute adds pattern and handler to relvent method tree, handler of
+// the same method and pattern will be replaced. Nothing is changed if
+// pattern is invalid or conflicts with registered patterns.
+func (r *router) addRoute(method string, pattern string, handlers ...HandlerFunc) error {
+	err := r.update(method, func(s *routerState) error {
+		if err := s.trees[method].Insert(pattern); err != nil {
+			return err
 		}
+		s.routes = s.routes.set(routeKey{method, pattern}, routeValue{handlers: handlers})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Debug("Route: %s %s", method, pattern)
+	return nil
+}
+
+// removeRoute removes pattern and handler from relvent method tree,
+// it reports whether the route exists.
+func (r *router) removeRoute(method string, pattern string) bool {
+	if _, ok := r.load().routes.get(routeKey{method, pattern}); !ok {
+		return false
 	}
 
-	return "", nil
+	removed := false
+	r.update(method, func(s *routerState) error {
+		if _, ok := s.routes.get(routeKey{method, pattern}); !ok {
+			return nil
+		}
+		s.trees[method].Remove(pattern)
+		s.routes = s.routes.delete(routeKey{method, pattern})
+		removed = true
+		return nil
+	})
+	if removed {
+		log.Info("Route removed: %s %s", method, pattern)
+	}
+	return removed
 }
 
-// applyMiddlewares adds middlewares into the existing handlerChain.
-// Those middlewares will be placed before exisiting handers.
-func (r *router) applyMiddlewares(method string, path string, middlewares handlerChain) {
-	fullPath := method + "-" + path
-	log.Debug("Applying middlewares for route: %s", fullPath)
-	oldHandlers := r.handlers[fullPath]
-	if oldHandlers != nil {
-		oldHandlers = append(middlewares, oldHandlers...)
-		r.handlers[fullPath] = oldHandlers
+// getRoute find registered pattern according to the path,
+// route parameters parsed are appended to params.
+func (r *router) getRoute(method string, path string, params *Params) string {
+	return r.load().getRoute(method, path, params)
+}
+
+// getRoute find registered pattern according to the path,
+// route parameters parsed are appended to params.
+func (s *routerState) getRoute(method string, path string, params *Params) string {
+	// check is http method registered
+	if root, ok := s.trees[method]; ok {
+		return root.Find(path, params)
 	}
+
+	return ""
 }
 
-// Routes returns a slice of registered routes' registered info.
-func (r *router) allRoutes() []RouteInfo {
-	list := make([]RouteInfo, len(r.handlers))
-	index := 0
-	for fullPath := range r.handlers {
+// setHandlers replaces handlerChains of registered routes at once,
+// unregistered ones are ignored, it returns number of routes replaced.
+func (r *router) setHandlers(handlers map[routeKey]handlerChain) int {
+	if len(handlers) == 0 {
+		return 0
+	}
 
-		parts := strings.Split(fullPath, "-")
-		method := parts[0]
-		route := parts[len(parts)-1]
+	n := 0
+	r.update("", func(s *routerState) error {
+		for key, chain := range handlers {
+			if value, ok := s.routes.get(key); ok {
+				value.handlers = chain
+				s.routes = s.routes.set(key, value)
+				n++
+			}
+		}
+		return nil
+	})
+	return n
+}
 
-		list[index] = RouteInfo{method, route}
-		index++
+// setDefaults replaces default values of optional parameters of
+// registered pattern, it reports whether the route exists.
+func (r *router) setDefaults(method string, pattern string, defaults Params) bool {
+	key := routeKey{method, pattern}
+	if _, ok := r.load().routes.get(key); !ok {
+		return false
 	}
 
-	return list
+	exists := false
+	r.update("", func(s *routerState) error {
+		var value routeValue
+		if value, exists = s.routes.get(key); exists {
+			value.defaults = defaults
+			s.routes = s.routes.set(key, value)
+		}
+		return nil
+	})
+	return exists
 }
 
 // handle handles the http request.
 func (r *router) handle(c *Context) {
+	// all lookups of the request use the same snapshot
+	s := r.load()
 
-	route, params := r.getRoute(c.Method, c.Path)
+	method := c.Method
+	route := s.getRoute(method, c.Path, &c.RouteParams)
+
+	// answer HEAD request by GET handlers if enabled
+	if route == "" && method == http.MethodHead && c.engine != nil && c.engine.HandleHEAD {
+		if route = s.getRoute(http.MethodGet, c.Path, &c.RouteParams); route != "" {
+			method = http.MethodGet
+			c.writer.ResponseWriter = headResponseWriter{c.writer.ResponseWriter}
+		}
+	}
 
 	if route != "" {
 		// if route found
-		fullPath := c.Method + "-" + route
-		handlers := r.handlers[fullPath]
-		c.handlers = handlers
-		c.RouteParams = params
+		value, _ := s.routes.get(routeKey{method, route})
+		c.handlers = value.handlers
+		if c.engine != nil && c.engine.UseRawPath {
+			unescapeParams(c.RouteParams)
+		}
+		if len(value.defaults) > 0 {
+			fillDefaults(value.defaults, c)
+		}
+	} else if to := s.redirectPath(c); to != "" {
+		// if route found after fixing the path
+		c.handlers = append(c.handlers, redirectHandler(to))
+	} else if allowed := s.allowedMethods(c); len(allowed) > 0 {
+		// if route registered under other methods
+		c.SetHeader("Allow", strings.Join(allowed, ", "))
+		if method == http.MethodOptions && c.engine != nil && c.engine.HandleOPTIONS {
+			c.handlers = append(c.handlers, optionsHandler)
+		} else {
+			c.handlers = append(c.handlers, HTTP405Handler)
+		}
 	} else {
 		// if route not found
 		c.handlers = append(c.handlers, HTTP404Handler)
@@ -139,28 +278,165 @@ func (r *router) handle(c *Context) {
 
 	c.Next()
 }
-func matchParams(registered []string, url []string) map[string]string {
-	params := make(map[string]string)
-	for i, reg := range registered {
-		if reg[0] == ':' {
-			params[reg[1:]] = url[i]
+
+// fillDefaults appends default values of optional parameters absent
+// from the request path to c.RouteParams.
+func fillDefaults(defaults Params, c *Context) {
+	for _, p := range defaults {
+		if _, ok := c.RouteParams.Get(p.Key); !ok {
+			c.RouteParams = append(c.RouteParams, p)
+		}
+	}
+}
+
+// unescapeParams unescapes values of params matched from escaped path,
+// the value is kept as it is if it is not escaped properly.
+func unescapeParams(params Params) {
+	for i := range params {
+		if value, err := url.PathUnescape(params[i].Value); err == nil {
+			params[i].Value = value
+		}
+	}
+}
+
+// redirectPath returns the registered path which the request should be
+// redirected to according to engine options, empty string if no such path.
+// HEAD request is redirected by GET routes as well if HandleHEAD is set.
+func (s *routerState) redirectPath(c *Context) string {
+	if c.engine == nil || c.Method == http.MethodConnect {
+		return ""
+	}
+	e := c.engine
+
+	var roots []*routerNode
+	if root, ok := s.trees[c.Method]; ok {
+		roots = append(roots, root)
+	}
+	if root, ok := s.trees[http.MethodGet]; ok && c.Method == http.MethodHead && e.HandleHEAD {
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return ""
+	}
+
+	candidates := make([]string, 0, 2)
+	if e.RedirectFixedPath {
+		candidates = append(candidates, cleanPath(c.Path))
+	} else {
+		candidates = append(candidates, c.Path)
+	}
+	if e.RedirectTrailingSlash {
+		candidates = append(candidates, toggleTrailingSlash(candidates[0]))
+	}
+
+	for _, path := range candidates {
+		for _, root := range roots {
+			if path != c.Path && root.Find(path, nil) != "" {
+				return path
+			}
+			if e.RedirectFixedPath && e.RedirectCaseInsensitive {
+				if fixed, found := root.FindCaseInsensitive(path); found && fixed != c.Path {
+					return fixed
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// redirectHandler redirects request to path specified with query string
+// kept, 301 is used for GET request and 308 for others.
+func redirectHandler(path string) HandlerFunc {
+	return func(c *Context) {
+		code := http.StatusPermanentRedirect
+		if c.Method == http.MethodGet {
+			code = http.StatusMovedPermanently
 		}
-		if reg[0] == '*' && len(reg) > 1 {
-			params[reg[1:]] = strings.Join(url[i:], "/")
+
+		u := *c.Request.URL
+		u.Path = path
+		u.RawPath = ""
+		if c.engine != nil && c.engine.UseRawPath {
+			// path is fixed from the path matched, in which "/" and "%"
+			// of segments are escaped, see rawRoutingPath
+			if unescaped, err := url.PathUnescape(path); err == nil {
+				segments := strings.Split(path, "/")
+				for i, segment := range segments {
+					if s, err := url.PathUnescape(segment); err == nil {
+						segments[i] = url.PathEscape(s)
+					}
+				}
+				u.Path, u.RawPath = unescaped, strings.Join(segments, "/")
+			}
 		}
+		c.Redirect(code, u.RequestURI())
 	}
-	return params
+}
+
+// allowedMethods returns a sorted slice of methods which the request
+// path is registered under, including HEAD and OPTIONS answered
+// automatically.
+func (s *routerState) allowedMethods(c *Context) []string {
+	var allowed []string
+	hasGET, hasHEAD, hasOPTIONS := false, false, false
+	for method, root := range s.trees {
+		if root.Find(c.Path, nil) != "" {
+			allowed = append(allowed, method)
+			hasGET = hasGET || method == http.MethodGet
+			hasHEAD = hasHEAD || method == http.MethodHead
+			hasOPTIONS = hasOPTIONS || method == http.MethodOptions
+		}
+	}
+
+	if len(allowed) > 0 && c.engine != nil {
+		if c.engine.HandleHEAD && hasGET && !hasHEAD {
+			allowed = append(allowed, http.MethodHead)
+		}
+		if c.engine.HandleOPTIONS && !hasOPTIONS {
+			allowed = append(allowed, http.MethodOptions)
+		}
+	}
+
+	sort.Strings(allowed)
+	return allowed
+}
+
+// optionsHandler answers OPTIONS request, "Allow" header is set
+// before it is called.
+func optionsHandler(c *Context) {
+	c.SetStatus(http.StatusNoContent)
+}
+
+// headResponseWriter discards response body, it is used when
+// HEAD request is answered by GET handlers.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write discards data and reports it as written.
+func (w headResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
 }
 
 //-------------------------- RouterNode --------------------------//
 
 // RouterNode is the basis unit of a router tree.
 type RouterNode interface {
-	// Find searchs the path and returns registered pattern
-	Find(path string) (pattern string)
+	// Find searchs the path and returns registered pattern,
+	// route parameters parsed are appended to params
+	Find(path string, params *Params) (pattern string)
+
+	// FindCaseInsensitive searchs the path case-insensitively and returns
+	// the path with the case fixed
+	FindCaseInsensitive(path string) (fixedPath string, found bool)
 
-	// Insert adds new pattern to router node
-	Insert(pattern string)
+	// Insert adds new pattern to router node, it returns error if
+	// pattern is invalid or conflicts with registered patterns
+	Insert(pattern string) error
+
+	// Remove removes pattern from router node,
+	// it reports whether pattern exists
+	Remove(pattern string) bool
 }
 
 // routerNode is a wrapper of container.RadixNode.
@@ -178,18 +454,35 @@ func NewRootNode() *routerNode {
 	return newNode
 }
 
-// Find searchs the path and returns registered pattern.
-func (n *routerNode) Find(path string) (reg string) {
-	parts := parsePattern(path)
-	node := n.RadixNode.Find(parts)
+// Find searchs the path and returns registered pattern,
+// route parameters parsed are appended to params.
+func (n *routerNode) Find(path string, params *Params) (reg string) {
+	node := n.RadixNode.Find(path, params)
 	if node != nil {
-		reg = node.GetPath()
+		reg = node.GetPattern()
 	}
 	return reg
 }
 
-// Insert adds new pattern to router node.
-func (n *routerNode) Insert(pattern string) {
-	parts := parsePattern(pattern)
-	n.RadixNode.Insert(parts)
+// FindCaseInsensitive searchs the path case-insensitively and returns
+// the path with the case fixed.
+func (n *routerNode) FindCaseInsensitive(path string) (string, bool) {
+	return n.RadixNode.FindCaseInsensitive(path)
+}
+
+// Insert adds new pattern to router node, it returns error if
+// pattern is invalid or conflicts with registered patterns.
+func (n *routerNode) Insert(pattern string) error {
+	return n.RadixNode.Insert(pattern)
+}
+
+// Remove removes pattern from router node, it reports whether pattern exists.
+func (n *routerNode) Remove(pattern string) bool {
+	return n.RadixNode.Remove(pattern)
+}
+
+// Clone returns a copy of router node, nodes below are shared until
+// they are modified by Insert or Remove.
+func (n *routerNode) Clone() *routerNode {
+	return &routerNode{n.RadixNode.Clone()}
 }
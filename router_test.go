@@ -2,7 +2,8 @@ package umeshu
 
 import (
 	"fmt"
-	"reflect"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 
@@ -34,27 +35,19 @@ var warningpaths = [][]string{
 }
 
 func newTestRouter() *router {
-	r := GlobalRouter
+	r := NewRouter()
 	for _, route := range routes {
 		r.addRoute("GET", route, nil)
 	}
 	return r.(*router)
 }
 
-func TestParsePattern(t *testing.T) {
-	ok := reflect.DeepEqual(parsePattern("/p/:name"), []string{"p", ":name"})
-	ok = ok && reflect.DeepEqual(parsePattern("/p/*"), []string{"p", "*"})
-	ok = ok && reflect.DeepEqual(parsePattern("/p/*name/*"), []string{"p", "*name"})
-	if !ok {
-		t.Fatal("test parsePattern failed")
-	}
-}
-
 func TestGetRoute(t *testing.T) {
 	r := newTestRouter()
 
 	for idx, path := range paths {
-		n, ps := r.getRoute("GET", path[0])
+		var ps Params
+		n := r.getRoute("GET", path[0], &ps)
 
 		if n == "" {
 			t.Fatal("nil shouldn't be returned")
@@ -64,7 +57,7 @@ func TestGetRoute(t *testing.T) {
 			t.Fatal(fmt.Sprintf("should match %s", routes[idx]))
 		}
 
-		if path[1] != "" && ps[path[1]] != path[2] {
+		if path[1] != "" && ps.ByName(path[1]) != path[2] {
 			t.Fatal(fmt.Sprintf("%s should be equal to '%s'", path[1], path[2]))
 		}
 
@@ -80,11 +73,255 @@ func BenchmarkGetRoute_goroutine(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
 		go func() {
+			params := make(Params, 0, defaultParamsCap)
 			for _, path := range paths {
-				r.getRoute("GET", path[0])
+				params = params[:0]
+				r.getRoute("GET", path[0], &params)
 			}
 			wg.Done()
 		}()
 	}
 	wg.Wait()
 }
+
+func TestGetRoute_zeroAllocs(t *testing.T) {
+	r := newTestRouter()
+	params := make(Params, 0, defaultParamsCap)
+
+	for _, path := range paths {
+		allocs := testing.AllocsPerRun(100, func() {
+			params = params[:0]
+			r.getRoute("GET", path[0], &params)
+		})
+		if allocs != 0 {
+			t.Errorf("getRoute %s allocates %v times, want 0", path[0], allocs)
+		}
+	}
+}
+
+func BenchmarkGetRoute_static(b *testing.B) {
+	r := newTestRouter()
+	params := make(Params, 0, defaultParamsCap)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params = params[:0]
+		r.getRoute("GET", "/hello/b/c", &params)
+	}
+}
+
+func BenchmarkGetRoute_param(b *testing.B) {
+	r := newTestRouter()
+	params := make(Params, 0, defaultParamsCap)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params = params[:0]
+		r.getRoute("GET", "/hello/umeshu", &params)
+	}
+}
+
+func TestSetHandlers(t *testing.T) {
+	r := NewRouter().(*router)
+	reply := func(body string) HandlerFunc {
+		return func(c *Context) { c.String(http.StatusOK, "%s", body) }
+	}
+	r.addRoute(http.MethodGet, "/users/:id", reply("v1"))
+	r.addRoute(http.MethodPost, "/users", reply("v1"))
+	trees := r.load().trees
+
+	n := r.setHandlers(map[routeKey]handlerChain{
+		{http.MethodGet, "/users/:id"}: {reply("v2")},
+		{http.MethodPost, "/users"}:    {reply("v2")},
+		{http.MethodGet, "/posts"}:     {reply("v2")}, // unregistered
+	})
+	if n != 2 {
+		t.Errorf("setHandlers() = %d, want 2", n)
+	}
+	for method, root := range r.load().trees {
+		if root != trees[method] {
+			t.Errorf("setHandlers() should not copy the tree of %s", method)
+		}
+	}
+
+	for method, path := range map[string]string{http.MethodGet: "/users/42", http.MethodPost: "/users"} {
+		rw := httptest.NewRecorder()
+		c := NewContext(rw, httptest.NewRequest(method, path, nil))
+		r.handle(c)
+		c.Free()
+		if rw.Body.String() != "v2" {
+			t.Errorf("%s %s = %q, want %q", method, path, rw.Body.String(), "v2")
+		}
+	}
+	if _, ok := r.load().routes.get(routeKey{http.MethodGet, "/posts"}); ok {
+		t.Errorf("setHandlers() should not add unregistered route")
+	}
+}
+
+func TestRawRoutingPath(t *testing.T) {
+	tests := []struct {
+		escaped string
+		want    string
+	}{
+		{"/files/a.txt", "/files/a.txt"},
+		{"/caf%C3%A9/1", "/café/1"},
+		{"/a%20b/a%2fb%2F", "/a b/a%2fb%2F"},
+		{"/100%25", "/100%25"},
+		{"/%zz/%4", "/%zz/%4"},
+	}
+
+	for _, tt := range tests {
+		if got := rawRoutingPath(tt.escaped); got != tt.want {
+			t.Errorf("rawRoutingPath(%q) = %q, want %q", tt.escaped, got, tt.want)
+		}
+	}
+}
+
+func TestHandle_methodNotAllowed(t *testing.T) {
+	r := NewRouter().(*router)
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+	r.addRoute(http.MethodGet, "/users/:id", ok)
+	r.addRoute(http.MethodPost, "/users/:id", ok)
+	r.addRoute(http.MethodPut, "/users", ok)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		allow  string
+	}{
+		{http.MethodGet, "/users/42", http.StatusOK, ""},
+		{http.MethodDelete, "/users/42", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodGet, "/users", http.StatusMethodNotAllowed, "PUT"},
+		{http.MethodPatch, "/posts", http.StatusNotFound, ""},
+		{http.MethodGet, "/posts", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		c := NewContext(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		r.handle(c)
+		c.Free()
+
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rw.Code, tt.code)
+		}
+		if allow := rw.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+}
+
+func TestHandle_autoHeadOptions(t *testing.T) {
+	r := NewRouter().(*router)
+	r.addRoute(http.MethodGet, "/users/:id", func(c *Context) {
+		c.SetHeader("X-User", c.GetRouteParam("id"))
+		c.String(http.StatusOK, "user")
+	})
+	r.addRoute(http.MethodPost, "/users/:id", func(c *Context) {})
+	r.addRoute(http.MethodGet, "/posts", func(c *Context) {})
+	r.addRoute(http.MethodOptions, "/posts", func(c *Context) {
+		c.String(http.StatusOK, "custom")
+	})
+	r.addRoute(http.MethodHead, "/posts", func(c *Context) {
+		c.SetStatus(http.StatusAccepted)
+	})
+
+	tests := []struct {
+		enabled bool
+		method  string
+		path    string
+		code    int
+		allow   string
+		body    string
+	}{
+		{true, http.MethodHead, "/users/42", http.StatusOK, "", ""},
+		{true, http.MethodOptions, "/users/42", http.StatusNoContent, "GET, HEAD, OPTIONS, POST", ""},
+		{true, http.MethodDelete, "/users/42", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST", "405 405 METHOD NOT ALLOWED: DELETE /users/42\n"},
+		{true, http.MethodOptions, "/posts", http.StatusOK, "", "custom"}, // registered handlers take precedence
+		{true, http.MethodHead, "/posts", http.StatusAccepted, "", ""},
+		{true, http.MethodOptions, "/comments", http.StatusNotFound, "", "404 404 NOT FOUND: /comments\n"},
+		{false, http.MethodHead, "/users/42", http.StatusMethodNotAllowed, "GET, POST", "405 405 METHOD NOT ALLOWED: HEAD /users/42\n"},
+		{false, http.MethodOptions, "/users/42", http.StatusMethodNotAllowed, "GET, POST", "405 405 METHOD NOT ALLOWED: OPTIONS /users/42\n"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		c := NewContext(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		c.engine = &Engine{HandleHEAD: tt.enabled, HandleOPTIONS: tt.enabled}
+		r.handle(c)
+		c.Free()
+
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rw.Code, tt.code)
+		}
+		if allow := rw.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+		if body := rw.Body.String(); body != tt.body {
+			t.Errorf("%s %s: body = %q, want %q", tt.method, tt.path, body, tt.body)
+		}
+	}
+
+	// GET handler runs for HEAD request
+	rw := httptest.NewRecorder()
+	c := NewContext(rw, httptest.NewRequest(http.MethodHead, "/users/42", nil))
+	c.engine = &Engine{HandleHEAD: true}
+	r.handle(c)
+	c.Free()
+	if user := rw.Header().Get("X-User"); user != "42" {
+		t.Errorf("HEAD /users/42: X-User = %q, want %q", user, "42")
+	}
+}
+
+func TestHandle_redirect(t *testing.T) {
+	r := NewRouter().(*router)
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+	r.addRoute(http.MethodGet, "/users", ok)
+	r.addRoute(http.MethodGet, "/users/:id/posts", ok)
+	r.addRoute(http.MethodPost, "/users", ok)
+	r.addRoute(http.MethodGet, "/assets/*filepath", ok)
+
+	all := &Engine{RedirectTrailingSlash: true, RedirectFixedPath: true, RedirectCaseInsensitive: true}
+	tests := []struct {
+		engine   *Engine
+		method   string
+		path     string
+		code     int
+		location string
+	}{
+		{all, http.MethodGet, "/users", http.StatusOK, ""},
+		{all, http.MethodGet, "/users/", http.StatusMovedPermanently, "/users"},
+		{all, http.MethodPost, "/users/", http.StatusPermanentRedirect, "/users"},
+		{all, http.MethodGet, "/users/?page=2", http.StatusMovedPermanently, "/users?page=2"},
+		{all, http.MethodGet, "/assets", http.StatusMovedPermanently, "/assets/"},
+		{all, http.MethodGet, "//users", http.StatusMovedPermanently, "/users"},
+		{all, http.MethodGet, "/posts/../users", http.StatusMovedPermanently, "/users"},
+		{all, http.MethodGet, "/Users", http.StatusMovedPermanently, "/users"},
+		{all, http.MethodGet, "/USERS/John/Posts/", http.StatusMovedPermanently, "/users/John/posts"},
+		{all, http.MethodGet, "/posts", http.StatusNotFound, ""},
+		{&Engine{RedirectTrailingSlash: true}, http.MethodGet, "/users/", http.StatusMovedPermanently, "/users"},
+		{&Engine{RedirectTrailingSlash: true}, http.MethodGet, "//users", http.StatusNotFound, ""},
+		{&Engine{RedirectFixedPath: true}, http.MethodGet, "//users/", http.StatusMovedPermanently, "/users"},
+		{&Engine{RedirectFixedPath: true}, http.MethodGet, "/Users", http.StatusNotFound, ""},
+		{&Engine{}, http.MethodGet, "/users/", http.StatusNotFound, ""},
+		{&Engine{RedirectTrailingSlash: true, HandleHEAD: true}, http.MethodHead, "/users/", http.StatusPermanentRedirect, "/users"},
+		{&Engine{RedirectFixedPath: true, HandleHEAD: true}, http.MethodHead, "//users", http.StatusPermanentRedirect, "/users"},
+		{&Engine{RedirectTrailingSlash: true}, http.MethodHead, "/users/", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		c := NewContext(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		c.engine = tt.engine
+		r.handle(c)
+		c.Free()
+
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rw.Code, tt.code)
+		}
+		if location := rw.Header().Get("Location"); location != tt.location {
+			t.Errorf("%s %s: Location = %q, want %q", tt.method, tt.path, location, tt.location)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package umeshu
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/knchan0x/umeshu/container"
+	"github.com/knchan0x/umeshu/log"
+)
+
+// Routes returns all registered routes of the engine, including routes
+// of hosts, sorted by host, pattern and method. The handler chain of
+// each route is the one resolved from group and route middlewares.
+// Routes with predicates, see (*Route).When, are listed one by one in
+// the order they are tried, followed by the default one.
+func (e *Engine) Routes() []RouteInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]RouteInfo, 0, len(e.routes))
+	for id, route := range e.routes {
+		name := strings.Join(e.routeNames(id), ", ")
+		for _, variant := range route.variants {
+			info := newRouteInfo(id.method, id.pattern, variant.handlerChain())
+			if id.host != nil {
+				info.Host = id.host.pattern
+			}
+			info.Name = name
+			info.Conditions = variant.conditions
+			list = append(list, info)
+		}
+	}
+	sortRoutes(list)
+	return list
+}
+
+// routeNames returns sorted names of the route, e.mu must be held.
+func (e *Engine) routeNames(id routeID) []string {
+	var names []string
+	for name, ids := range e.names {
+		if containsRouteID(ids, id) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newRouteInfo returns RouteInfo of the route, the last of handlers
+// is the handler and others are middlewares.
+func newRouteInfo(method string, pattern string, handlers handlerChain) RouteInfo {
+	info := RouteInfo{
+		Method:      method,
+		Pattern:     pattern,
+		Middlewares: []string{},
+	}
+	if len(handlers) == 0 {
+		return info
+	}
+	for _, middleware := range handlers[:len(handlers)-1] {
+		info.Middlewares = append(info.Middlewares, nameOfFunction(middleware))
+	}
+	info.Handler = nameOfFunction(handlers[len(handlers)-1])
+	return info
+}
+
+// sortRoutes sorts routes by host, pattern and method.
+func sortRoutes(list []RouteInfo) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Host != list[j].Host {
+			return list[i].Host < list[j].Host
+		}
+		if list[i].Pattern != list[j].Pattern {
+			return list[i].Pattern < list[j].Pattern
+		}
+		return list[i].Method < list[j].Method
+	})
+}
+
+// nameOfFunction returns the name of function, closures are named
+// after the function creating it, i.e. "github.com/knchan0x/umeshu.Logging.func1".
+func nameOfFunction(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// logRoutes prints the route table of the engine through log package.
+func (e *Engine) logRoutes() {
+	routes := e.Routes()
+	if len(routes) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, route := range routes {
+		pattern := route.Pattern
+		if route.Host != "" {
+			pattern = route.Host + pattern
+		}
+		name := route.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t--> %s\t(%s)\n",
+			route.Method, pattern, name, route.Handler, strings.Join(route.Middlewares, ", "))
+	}
+	w.Flush()
+
+	log.Info("Routes:")
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		log.Info("  %s", line)
+	}
+}
+
+// EnableRouteDebug adds a handler listing all registered routes at
+// "/debug/routes". It responses JSON by default, and a html table if
+// the request accepts "text/html", i.e. from browser.
+func (e *Engine) EnableRouteDebug() {
+	e.GET("/debug/routes", routeDebugHandler(e))
+}
+
+// routeDebugHandler responses registered routes of engine.
+func routeDebugHandler(e *Engine) HandlerFunc {
+	return func(c *Context) {
+		routes := e.Routes()
+		if !strings.Contains(c.Request.Header.Get("Accept"), "text/html") {
+			c.JSON(http.StatusOK, routes)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := routeDebugTemplate.Execute(&buf, routes); err != nil {
+			log.Error("unable to execute route table: %s", err)
+			c.Fail(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+		c.HTML(http.StatusOK, buf.String())
+	}
+}
+
+var routeDebugTemplate = template.Must(template.New("routes").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Routes</title></head>
+<body>
+<table>
+<tr><th>Method</th><th>Host</th><th>Pattern</th><th>Name</th><th>Handler</th><th>Middlewares</th></tr>
+{{- range . }}
+<tr><td>{{ .Method }}</td><td>{{ .Host }}</td><td>{{ .Pattern }}</td><td>{{ .Name }}</td><td>{{ .Handler }}</td><td>{{ range $i, $m := .Middlewares }}{{ if $i }}<br>{{ end }}{{ $m }}{{ end }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// RouteError is the error of registering a route, Kind tells whether the
+// pattern is invalid or how it conflicts with registered routes.
+type RouteError struct {
+	Method string
+	Host   string // empty if not bound to any host
+	*container.PatternError
+}
+
+// Error returns the description of error.
+func (e *RouteError) Error() string {
+	return e.Method + " " + e.Host + e.PatternError.Error()
+}
+
+// RouteErrors collects errors of registering routes.
+type RouteErrors []*RouteError
+
+// Error returns the description of all errors.
+func (e RouteErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d route errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Validate returns RouteErrors if any route is invalid or conflicts with
+// other routes when it is registered, nil otherwise. Conflicts include
+// shadowed routes, i.e. "/users/:name" with "/users/:id", the same method
+// and pattern registered twice and wildcard overriding other wildcard.
+func (e *Engine) Validate() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.conflicts) == 0 {
+		return nil
+	}
+	return append(RouteErrors(nil), e.conflicts...)
+}
+
+// addConflict logs and records the error of registering route id,
+// e.mu must be held.
+func (e *Engine) addConflict(id routeID, err error) *RouteError {
+	patternErr, ok := err.(*container.PatternError)
+	if !ok {
+		patternErr = &container.PatternError{
+			Kind:    container.InvalidPattern,
+			Pattern: id.pattern,
+			Reason:  err.Error(),
+		}
+	}
+
+	routeErr := &RouteError{Method: id.method, PatternError: patternErr}
+	if id.host != nil {
+		routeErr.Host = id.host.pattern
+	}
+	log.Warning("route error: %s", routeErr)
+	e.conflicts = append(e.conflicts, routeErr)
+	return routeErr
+}
+
+// removeConflict removes the error recorded by addConflict,
+// e.mu must be held.
+func (e *Engine) removeConflict(routeErr *RouteError) {
+	for i, err := range e.conflicts {
+		if err == routeErr {
+			e.conflicts = append(e.conflicts[:i:i], e.conflicts[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package umeshu
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knchan0x/umeshu/container"
+)
+
+func routeTestMiddleware(c *Context) { c.Next() }
+
+func routeTestHandler(c *Context) { c.String(http.StatusOK, "ok") }
+
+func TestEngine_Routes(t *testing.T) {
+	e := New()
+	e.Use(routeTestMiddleware)
+	e.GET("/users/:id", routeTestHandler).Name("user-show")
+	e.PUT("/users/:id", routeTestHandler)
+	e.Host("admin.example.com").POST("/users", routeTestMiddleware, routeTestHandler)
+	e.Host("admin.example.com").GET("/users/:id", routeTestHandler)
+	e.EnableRouteDebug()
+
+	pkg := "github.com/knchan0x/umeshu."
+	want := []RouteInfo{
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/debug/routes",
+			Handler:     pkg + "routeDebugHandler.func1",
+			Middlewares: []string{pkg + "routeTestMiddleware"},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/users/:id",
+			Name:        "user-show",
+			Handler:     pkg + "routeTestHandler",
+			Middlewares: []string{pkg + "routeTestMiddleware"},
+		},
+		{
+			Method:      http.MethodPut,
+			Pattern:     "/users/:id",
+			Handler:     pkg + "routeTestHandler",
+			Middlewares: []string{pkg + "routeTestMiddleware"},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/users",
+			Host:        "admin.example.com",
+			Handler:     pkg + "routeTestHandler",
+			Middlewares: []string{pkg + "routeTestMiddleware", pkg + "routeTestMiddleware"},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/users/:id",
+			Host:        "admin.example.com",
+			Handler:     pkg + "routeTestHandler",
+			Middlewares: []string{pkg + "routeTestMiddleware"},
+		},
+	}
+
+	if routes := e.Routes(); !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() = %+v, want %+v", routes, want)
+	}
+
+	rw := httptest.NewRecorder()
+	e.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/debug/routes", nil))
+	var got []RouteInfo
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("get /debug/routes: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("get /debug/routes = %+v, want %+v", got, want)
+	}
+}
+
+func TestEngine_Validate(t *testing.T) {
+	ok := func(c *Context) { c.String(http.StatusOK, "ok") }
+
+	e := New()
+	e.GET("/users/:id", ok)
+	e.GET("/files/*path", ok)
+	if err := e.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	e.GET("/users/:name/posts", ok)                 // shadowed by "/users/:id"
+	e.GET("/files/*name", ok)                       // overrides "/files/*path"
+	e.GET("/users/:id", ok)                         // duplicated
+	e.GET("/users/:id", ok)                         // reported once
+	e.Host("admin.example.com").GET("/users/:", ok) // invalid
+
+	err := e.Validate()
+	errs, isRouteErrors := err.(RouteErrors)
+	if !isRouteErrors {
+		t.Fatalf("Validate() = %#v, want RouteErrors", err)
+	}
+	want := []struct {
+		kind container.ConflictKind
+		host string
+	}{
+		{container.ShadowedPattern, ""},
+		{container.WildcardOverride, ""},
+		{container.DuplicatePattern, ""},
+		{container.InvalidPattern, "admin.example.com"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Validate() = %v, want %d errors", err, len(want))
+	}
+	for i, w := range want {
+		if errs[i].Kind != w.kind || errs[i].Host != w.host {
+			t.Errorf("error %d = %s, want kind %s and host %q", i, errs[i], w.kind, w.host)
+		}
+	}
+
+	// conflicting routes are not registered
+	for path, want := range map[string]string{"/users/42/posts": "", "/files/a.txt": "/files/*path"} {
+		if route := e.router.getRoute(http.MethodGet, path, nil); route != want {
+			t.Errorf("get %s: route = %q, want %q", path, route, want)
+		}
+	}
+
+	// errors of replacing are removed with the route
+	e.GET("/users/:id", ok)
+	e.Remove(HTTP_GET, "/users/:id")
+	if err := e.Validate(); err == nil || len(err.(RouteErrors)) != len(want)-1 {
+		t.Errorf("Validate() after removing the replaced route = %v, want %d errors", err, len(want)-1)
+	}
+
+	// strict mode refuses to start
+	e.Strict = true
+	defer func() {
+		if recover() == nil {
+			t.Error("prepareServer() in strict mode does not panic with route errors")
+		}
+	}()
+	e.prepareServer(":0")
+}
+
+func TestEngine_Replace(t *testing.T) {
+	reply := func(body string) HandlerFunc {
+		return func(c *Context) { c.String(http.StatusOK, "%s", body) }
+	}
+	get := func(e *Engine, path string, header string) string {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set("X-Version", header)
+		}
+		e.ServeHTTP(rw, req)
+		return rw.Body.String()
+	}
+
+	e := New()
+	e.GET("/users/:id", reply("v1"))
+	e.Replace(http.MethodGet, "/users/:id", reply("v2"))
+	e.Replace(http.MethodGet, "/posts", reply("posts")) // registered if not exists
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+	for path, want := range map[string]string{"/users/42": "v2", "/posts": "posts"} {
+		if got := get(e, path, ""); got != want {
+			t.Errorf("get %s = %q, want %q", path, got, want)
+		}
+	}
+
+	// the replaced one is restored as the default route
+	e.Replace(http.MethodGet, "/users/:id", reply("v3")).Header("X-Version", "3")
+	for header, want := range map[string]string{"": "v2", "3": "v3"} {
+		if got := get(e, "/users/42", header); got != want {
+			t.Errorf("get /users/42 with X-Version %q = %q, want %q", header, got, want)
+		}
+	}
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	// error of replacing by accident is still reported
+	e.GET("/posts", reply("posts"))
+	e.Replace(http.MethodGet, "/posts", reply("posts"))
+	if err := e.Validate(); err == nil || len(err.(RouteErrors)) != 1 {
+		t.Errorf("Validate() = %v, want 1 error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package umeshu
+
+// routeTable is a persistent map of registered routes. set and delete
+// return a new table and leave the table itself unchanged, only nodes on
+// the path to the route are copied, so that routerState can be updated
+// without copying all registered routes.
+//
+// It is a hash array mapped trie, each level of the trie is indexed by
+// the next tableBits bits of the hash of routeKey.
+type routeTable struct {
+	root *tableNode
+	size int
+}
+
+// routeValue is what registered for a route.
+type routeValue struct {
+	handlers handlerChain
+
+	// default values of optional parameters, i.e. "/posts/:page?"
+	defaults Params
+}
+
+const (
+	tableBits  = 4
+	tableWidth = 1 << tableBits
+)
+
+// tableNode is either a branch with children or a leaf with entries,
+// entries of a leaf have the same hash.
+type tableNode struct {
+	children []*tableNode // nil for leaf
+	hash     uint64
+	entries  []tableEntry
+}
+
+type tableEntry struct {
+	key   routeKey
+	value routeValue
+}
+
+// len returns number of routes in the table.
+func (t routeTable) len() int {
+	return t.size
+}
+
+// get returns the value of key, it reports whether key exists.
+func (t routeTable) get(key routeKey) (routeValue, bool) {
+	return t.root.get(key.hash(), key)
+}
+
+// get returns the value of key below the node, h is the hash of key.
+func (n *tableNode) get(h uint64, key routeKey) (routeValue, bool) {
+	for shift := uint(0); n != nil; shift += tableBits {
+		if n.children == nil {
+			for _, e := range n.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return routeValue{}, false
+		}
+		n = n.children[(h>>shift)%tableWidth]
+	}
+	return routeValue{}, false
+}
+
+// set returns a copy of the table with value of key set.
+func (t routeTable) set(key routeKey, value routeValue) routeTable {
+	root, added := t.root.set(key.hash(), 0, key, value)
+	t.root = root
+	if added {
+		t.size++
+	}
+	return t
+}
+
+// delete returns a copy of the table without key.
+func (t routeTable) delete(key routeKey) routeTable {
+	root, deleted := t.root.delete(key.hash(), 0, key)
+	if deleted {
+		t.root = root
+		t.size--
+	}
+	return t
+}
+
+// set returns a copy of the node with value of key set, h is the hash of
+// key and shift is the level of the node in bits. It reports whether key
+// is added rather than replaced.
+func (n *tableNode) set(h uint64, shift uint, key routeKey, value routeValue) (*tableNode, bool) {
+	switch {
+	case n == nil:
+		return &tableNode{hash: h, entries: []tableEntry{{key, value}}}, true
+
+	case n.children != nil:
+		i := (h >> shift) % tableWidth
+		child, added := n.children[i].set(h, shift+tableBits, key, value)
+		clone := &tableNode{children: append([]*tableNode(nil), n.children...)}
+		clone.children[i] = child
+		return clone, added
+
+	case n.hash == h:
+		entries := make([]tableEntry, 0, len(n.entries)+1)
+		added := true
+		for _, e := range n.entries {
+			if e.key == key {
+				e.value = value
+				added = false
+			}
+			entries = append(entries, e)
+		}
+		if added {
+			entries = append(entries, tableEntry{key, value})
+		}
+		return &tableNode{hash: h, entries: entries}, added
+
+	default:
+		// split the leaf by the next bits of the hashes
+		branch := &tableNode{children: make([]*tableNode, tableWidth)}
+		branch.children[(n.hash>>shift)%tableWidth] = n
+		return branch.set(h, shift, key, value)
+	}
+}
+
+// delete returns a copy of the node without key, nil if the node becomes
+// empty. It reports whether key exists, the node itself is returned if
+// not.
+func (n *tableNode) delete(h uint64, shift uint, key routeKey) (*tableNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if n.children == nil {
+		for j, e := range n.entries {
+			if e.key != key {
+				continue
+			}
+			if len(n.entries) == 1 {
+				return nil, true
+			}
+			entries := append(append([]tableEntry(nil), n.entries[:j]...), n.entries[j+1:]...)
+			return &tableNode{hash: n.hash, entries: entries}, true
+		}
+		return n, false
+	}
+
+	i := (h >> shift) % tableWidth
+	child, deleted := n.children[i].delete(h, shift+tableBits, key)
+	if !deleted {
+		return n, false
+	}
+	clone := &tableNode{children: append([]*tableNode(nil), n.children...)}
+	clone.children[i] = child
+
+	// the branch is replaced by its only leaf, if any, so that
+	// the trie shrinks with routes removed
+	var last *tableNode
+	count := 0
+	for _, c := range clone.children {
+		if c != nil {
+			last = c
+			count++
+		}
+	}
+	switch {
+	case count == 0:
+		return nil, true
+	case count == 1 && last.children == nil:
+		return last, true
+	}
+	return clone, true
+}
+
+// hash returns FNV-1a hash of the key.
+func (k routeKey) hash() uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(k.method); i++ {
+		h ^= uint64(k.method[i])
+		h *= prime
+	}
+	h *= prime // separator, so that method and pattern are not mixed up
+	for i := 0; i < len(k.pattern); i++ {
+		h ^= uint64(k.pattern[i])
+		h *= prime
+	}
+	return h
+}
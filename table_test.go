@@ -0,0 +1,111 @@
+package umeshu
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	var keys []routeKey
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		for i := 0; i < 500; i++ {
+			keys = append(keys, routeKey{method, fmt.Sprintf("/items/%d", i)})
+		}
+	}
+	value := func(key routeKey) routeValue {
+		return routeValue{defaults: Params{{Key: "key", Value: key.method + " " + key.pattern}}}
+	}
+	check := func(name string, table routeTable, want map[routeKey]bool) {
+		t.Helper()
+		if table.len() != len(want) {
+			t.Errorf("%s: len() = %d, want %d", name, table.len(), len(want))
+		}
+		for _, key := range keys {
+			got, ok := table.get(key)
+			if ok != want[key] {
+				t.Errorf("%s: get(%v) reports %t, want %t", name, key, ok, want[key])
+			} else if ok && got.defaults[0].Value != value(key).defaults[0].Value {
+				t.Errorf("%s: get(%v) = %v, want %v", name, key, got, value(key))
+			}
+		}
+	}
+
+	var table routeTable
+	all := make(map[routeKey]bool)
+	for _, key := range keys {
+		table = table.set(key, value(key))
+		all[key] = true
+	}
+	table = table.set(keys[0], value(keys[0])) // replaced
+	check("set", table, all)
+
+	half := make(map[routeKey]bool)
+	removed := table
+	for i, key := range keys {
+		if i%2 == 0 {
+			removed = removed.delete(key)
+		} else {
+			half[key] = true
+		}
+	}
+	removed = removed.delete(routeKey{http.MethodPut, "/items/0"}) // unregistered
+	check("delete", removed, half)
+	check("original", table, all)
+
+	for _, key := range keys {
+		removed = removed.delete(key)
+	}
+	check("delete all", removed, nil)
+	if removed.root != nil {
+		t.Errorf("delete all: root should be nil")
+	}
+}
+
+func TestTableNode_collision(t *testing.T) {
+	a := routeKey{http.MethodGet, "/a"}
+	b := routeKey{http.MethodGet, "/b"}
+	c := routeKey{http.MethodGet, "/c"}
+
+	tests := []struct {
+		name   string
+		hashes map[routeKey]uint64
+	}{
+		{"same hash", map[routeKey]uint64{a: 1, b: 1, c: 1}},
+		{"differ in the last bits", map[routeKey]uint64{a: 1, b: 1 | 1<<63, c: 1 | 1<<62}},
+		{"differ in the first bits", map[routeKey]uint64{a: 1, b: 2, c: 3}},
+	}
+
+	for _, tt := range tests {
+		var root *tableNode
+		for _, key := range []routeKey{a, b, c} {
+			var added bool
+			root, added = root.set(tt.hashes[key], 0, key, routeValue{defaults: Params{{Key: key.pattern}}})
+			if !added {
+				t.Errorf("%s: set(%v) should add the key", tt.name, key)
+			}
+		}
+
+		deleted := root
+		for _, key := range []routeKey{a, b} {
+			var ok bool
+			if deleted, ok = deleted.delete(tt.hashes[key], 0, key); !ok {
+				t.Errorf("%s: delete(%v) should find the key", tt.name, key)
+			}
+		}
+		// the only leaf left becomes the root
+		if deleted == nil || deleted.children != nil || len(deleted.entries) != 1 || deleted.entries[0].key != c {
+			t.Errorf("%s: delete() should leave the leaf of %v only", tt.name, c)
+		}
+		if _, ok := deleted.delete(tt.hashes[a], 0, a); ok {
+			t.Errorf("%s: delete(%v) twice should not find the key", tt.name, a)
+		}
+
+		// the original trie is not modified
+		for _, key := range []routeKey{a, b, c} {
+			if got, ok := root.get(tt.hashes[key], key); !ok || got.defaults[0].Key != key.pattern {
+				t.Errorf("%s: get(%v) = %v after delete, want %q", tt.name, key, got, key.pattern)
+			}
+		}
+	}
+}
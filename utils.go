@@ -1,6 +1,7 @@
 package umeshu
 
 import (
+	"path"
 	"strings"
 
 	"github.com/knchan0x/umeshu/log"
@@ -8,6 +9,9 @@ import (
 
 // cleanPrefix returns clean prefix.
 func cleanPrefix(prefix string) string {
+	if prefix == "" {
+		return prefix
+	}
 	if prefix[0] != '/' {
 		prefix = "/" + prefix
 	}
@@ -17,20 +21,71 @@ func cleanPrefix(prefix string) string {
 	return prefix
 }
 
-// parsePattern parses path into string slice.
-func parsePattern(pattern string) []string {
-	s := strings.Split(pattern, "/")
+// cleanPath returns the shortest path equivalent to p by purely lexical
+// processing, it follows the semantics of path.Clean.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// toggleTrailingSlash removes the trailing slash of p if exists,
+// otherwise adds one.
+func toggleTrailingSlash(p string) string {
+	if len(p) > 1 && p[len(p)-1] == '/' {
+		return p[:len(p)-1]
+	}
+	if p == "/" {
+		return p
+	}
+	return p + "/"
+}
 
-	parts := make([]string, 0)
-	for _, part := range s {
-		if part != "" {
-			parts = append(parts, part)
-			if part[0] == '*' {
-				break // only one "*" is allowed
+// rawRoutingPath unescapes escaped path except "%2F" and "%25", so that
+// it matches patterns of unescaped text while escaped slashes are not
+// regarded as separators, i.e. "/caf%C3%A9/a%2Fb" becomes "/café/a%2Fb".
+// Invalid escapes are kept as it is.
+func rawRoutingPath(escaped string) string {
+	i := strings.IndexByte(escaped, '%')
+	if i < 0 {
+		return escaped
+	}
+
+	var b strings.Builder
+	b.Grow(len(escaped))
+	b.WriteString(escaped[:i])
+	for ; i < len(escaped); i++ {
+		if escaped[i] == '%' && i+2 < len(escaped) && isHex(escaped[i+1]) && isHex(escaped[i+2]) {
+			c := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+			if c != '/' && c != '%' {
+				b.WriteByte(c)
+				i += 2
+				continue
 			}
 		}
+		b.WriteByte(escaped[i])
+	}
+	return b.String()
+}
+
+// isHex reports whether c is a hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// unhex returns the value of hexadecimal digit c.
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
 	}
-	return parts
+	return c - '0'
 }
 
 // asset check guard condition, panic if not true.
@@ -0,0 +1,387 @@
+package umeshu
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knchan0x/umeshu/log"
+)
+
+// ValidatorFunc reports whether value satisfies the rule, param is the
+// parameter of the rule, i.e. "3" of "min=3", empty if not given.
+// value is never a pointer, nil pointers are only checked by "required".
+type ValidatorFunc func(value reflect.Value, param string) bool
+
+// builtinValidators are validators available to all engines, "required",
+// "omitempty" and "dive" are handled by validateField.
+var builtinValidators = map[string]ValidatorFunc{
+	"min":   func(v reflect.Value, param string) bool { return compare(v, param) >= 0 },
+	"max":   func(v reflect.Value, param string) bool { return compare(v, param) <= 0 },
+	"len":   func(v reflect.Value, param string) bool { return compare(v, param) == 0 },
+	"email": isEmail,
+	"oneof": isOneOf,
+}
+
+// RegisterValidator registers custom validator used by `validate` tags,
+// i.e. RegisterValidator("even", isEven) for `validate:"even"`. Built-in
+// validator of the same name is replaced. It is safe to call while
+// http.Server is serving http requests.
+func (e *Engine) RegisterValidator(name string, fn ValidatorFunc) {
+	assert(name != "" && !strings.ContainsAny(name, ",="), "invalid validator name")
+	assert(name != "required" && name != "omitempty" && name != "dive", "validator name is reserved: "+name)
+	assert(fn != nil, "validator must not be nil")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// copy on write, so that validators can be read without lock
+	old := e.loadValidators()
+	validators := make(map[string]ValidatorFunc, len(old)+1)
+	for n, v := range old {
+		validators[n] = v
+	}
+	validators[name] = fn
+	e.validators.Store(validators)
+}
+
+// loadValidators returns custom validators.
+func (e *Engine) loadValidators() map[string]ValidatorFunc {
+	return e.validators.Load().(map[string]ValidatorFunc)
+}
+
+// ValidationError reports the field failed on the rule of `validate` tag.
+type ValidationError struct {
+	Field   string `json:"field"`           // path of struct field, i.e. "Items[0].Name"
+	Rule    string `json:"rule"`            // i.e. "min"
+	Param   string `json:"param,omitempty"` // i.e. "3"
+	Message string `json:"message"`         // i.e. "length must be at least 3"
+}
+
+// Error returns the description of error.
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationErrors collects all fields failed on validation.
+type ValidationErrors []*ValidationError
+
+// Error returns the description of all errors.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d validation errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Validate validates obj, which must be a struct or pointer to struct, by
+// `validate` tags. Rules are separated by comma, i.e.
+// `validate:"required,min=3,max=64"`, the built-in rules are:
+//
+//	required   not zero value, non-empty for string, slice and map
+//	omitempty  skips other rules if it is zero value
+//	min, max   minimum and maximum of number, length of string, slice and map
+//	len        exact length of string, slice and map, or value of number
+//	email      valid email address without name, i.e. "alice@example.com"
+//	oneof      one of values separated by space, i.e. "oneof=asc desc"
+//	dive       rules after it apply to elements of slice, array or map
+//
+// Fields of nested structs and elements of slices, arrays and maps of
+// structs are validated as well. Custom rules are registered by
+// (*Engine).RegisterValidator. It returns ValidationErrors listing all
+// fields failed on validation.
+func (c *Context) Validate(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	var parents []reflect.Value
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		parents = append(parents, v)
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("validation target must be a struct, got %T", obj)
+	}
+
+	var custom map[string]ValidatorFunc
+	if c.engine != nil {
+		custom = c.engine.loadValidators()
+	}
+
+	var errs ValidationErrors
+	validateStruct(v, "", custom, parents, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FailBinding responses 400 for the error returned by binding and
+// validation. ValidationErrors and BindingErrors are listed by field
+// in JSON, i.e. {"error": "...", "fields": [{"field": "Name", ...}]}.
+func (c *Context) FailBinding(err error) {
+	switch errs := err.(type) {
+	case ValidationErrors:
+		c.JSON(http.StatusBadRequest, JSONData{"error": "validation failed", "fields": errs})
+	case BindingErrors:
+		fields := make([]JSONData, len(errs))
+		for i, e := range errs {
+			fields[i] = JSONData{"field": e.Field, "source": e.Source, "key": e.Key, "message": e.Err.Error()}
+		}
+		c.JSON(http.StatusBadRequest, JSONData{"error": "binding failed", "fields": fields})
+	default:
+		c.JSON(http.StatusBadRequest, JSONData{"error": err.Error()})
+	}
+}
+
+// validateStruct validates fields of struct v, prefix is the path of v
+// and parents are pointers dereferenced to reach v.
+func validateStruct(v reflect.Value, prefix string, custom map[string]ValidatorFunc, parents []reflect.Value, errs *ValidationErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue // unexported
+		}
+		tag := sf.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+
+		path := prefix + sf.Name
+		if sf.Anonymous && tag == "" {
+			// fields are promoted
+			f, parents := v.Field(i), parents
+			for f.Kind() == reflect.Ptr && !f.IsNil() && !isParent(f, parents) {
+				parents = append(parents, f)
+				f = f.Elem()
+			}
+			if f.Kind() == reflect.Struct {
+				validateStruct(f, prefix, custom, parents, errs)
+			}
+			continue
+		}
+
+		var rules []string
+		if tag != "" {
+			rules = strings.Split(tag, ",")
+		}
+		validateField(v.Field(i), path, rules, custom, parents, errs)
+	}
+}
+
+// validateField validates v by rules, then fields of nested structs,
+// pointers of parents are not followed again.
+func validateField(v reflect.Value, path string, rules []string, custom map[string]ValidatorFunc, parents []reflect.Value, errs *ValidationErrors) {
+	for i, rule := range rules {
+		name, param := rule, ""
+		if idx := strings.IndexByte(rule, '='); idx >= 0 {
+			name, param = rule[:idx], rule[idx+1:]
+		}
+
+		switch name {
+		case "required":
+			if isEmpty(v) {
+				*errs = append(*errs, newValidationError(v, path, name, param))
+				return
+			}
+			continue
+		case "omitempty":
+			if isEmpty(v) {
+				return
+			}
+			continue
+		}
+
+		// other rules are not applied to nil pointer
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+
+		if name == "dive" {
+			validateElements(v, path, rules[i+1:], custom, parents, errs)
+			return
+		}
+
+		fn, ok := custom[name]
+		if !ok {
+			if fn, ok = builtinValidators[name]; !ok {
+				log.Panic("unknown validation rule %q of field %s", name, path)
+			}
+		}
+		if !fn(v, param) {
+			*errs = append(*errs, newValidationError(v, path, name, param))
+			return
+		}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			if isParent(v, parents) {
+				return // cyclic
+			}
+			parents = append(parents, v)
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() != timeType {
+			validateStruct(v, path+".", custom, parents, errs)
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		validateElements(v, path, nil, custom, parents, errs)
+	}
+}
+
+// validateElements validates elements of slice, array or map v by rules,
+// as well as fields of them if they are structs.
+func validateElements(v reflect.Value, path string, rules []string, custom map[string]ValidatorFunc, parents []reflect.Value, errs *ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if len(rules) == 0 && !hasStruct(v.Type().Elem()) {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			validateField(v.Index(i), fmt.Sprintf("%s[%d]", path, i), rules, custom, parents, errs)
+		}
+	case reflect.Map:
+		if len(rules) == 0 && !hasStruct(v.Type().Elem()) {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			validateField(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), rules, custom, parents, errs)
+		}
+	default:
+		log.Panic("dive can only be used on slice, array or map, field %s is %s", path, v.Type())
+	}
+}
+
+// isParent reports whether pointer v is one of parents.
+func isParent(v reflect.Value, parents []reflect.Value) bool {
+	for _, parent := range parents {
+		if parent.Pointer() == v.Pointer() && parent.Type() == v.Type() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStruct reports whether values of t may have fields to validate.
+func hasStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return t != timeType
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return hasStruct(t.Elem())
+	case reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// isEmpty reports whether v is zero value, or empty string, slice or map.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// compare compares length of string, slice and map, or value of number
+// with param, it returns -1, 0 or +1.
+func compare(v reflect.Value, param string) int {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		log.Panic("invalid parameter of validation rule: %q", param)
+	}
+
+	var f float64
+	switch v.Kind() {
+	case reflect.String:
+		f = float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		f = float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f = v.Float()
+	default:
+		log.Panic("unable to compare %s with %q", v.Type(), param)
+	}
+
+	switch {
+	case f < n:
+		return -1
+	case f > n:
+		return 1
+	}
+	return 0
+}
+
+// isEmail reports whether v is a valid email address without name.
+func isEmail(v reflect.Value, _ string) bool {
+	if v.Kind() != reflect.String {
+		return false
+	}
+	addr, err := mail.ParseAddress(v.String())
+	return err == nil && addr.Name == "" && addr.Address == v.String()
+}
+
+// isOneOf reports whether v is one of the values separated by space.
+func isOneOf(v reflect.Value, param string) bool {
+	s := fmt.Sprint(v.Interface())
+	for _, value := range strings.Fields(param) {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newValidationError returns error of v failed on the rule.
+func newValidationError(v reflect.Value, path string, rule string, param string) *ValidationError {
+	length := false
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		length = true
+	}
+
+	var msg string
+	switch rule {
+	case "required":
+		msg = "is required"
+	case "min":
+		msg = "must be at least " + param
+	case "max":
+		msg = "must be at most " + param
+	case "len":
+		msg = "must be " + param
+	case "email":
+		msg = "must be a valid email address"
+	case "oneof":
+		msg = "must be one of [" + param + "]"
+	default:
+		msg = "failed on " + rule
+	}
+	if length && (rule == "min" || rule == "max" || rule == "len") {
+		msg = "length " + msg
+	}
+	return &ValidationError{Field: path, Rule: rule, Param: param, Message: msg}
+}
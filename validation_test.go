@@ -0,0 +1,112 @@
+package umeshu
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateTestItem struct {
+	SKU      string `json:"sku" validate:"required,len=6"`
+	Quantity int    `json:"quantity" validate:"min=1,even"`
+}
+
+type validateTestOrder struct {
+	Name   string              `json:"name" validate:"required,min=3,max=64"`
+	Email  string              `json:"email" validate:"omitempty,email"`
+	Sort   string              `query:"sort" validate:"omitempty,oneof=asc desc"`
+	Tags   []string            `json:"tags" validate:"max=2,dive,min=2"`
+	Items  []*validateTestItem `json:"items" validate:"required"`
+	Coupon *validateTestItem   `json:"coupon"`
+}
+
+func TestEngine_validation(t *testing.T) {
+	e := New()
+	e.RegisterValidator("even", func(v reflect.Value, _ string) bool { return v.Int()%2 == 0 })
+	e.POST("/orders", func(c *Context) {
+		var order validateTestOrder
+		if err := c.Bind(&order); err != nil {
+			c.FailBinding(err)
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		code   int
+		fields []ValidationError
+	}{
+		{"valid", "?sort=asc", `{"name":"alice","email":"alice@example.com","items":[{"sku":"ABC123","quantity":2}]}`,
+			http.StatusOK, nil},
+		{"required", "", `{}`, http.StatusBadRequest, []ValidationError{
+			{Field: "Name", Rule: "required", Message: "is required"},
+			{Field: "Items", Rule: "required", Message: "is required"},
+		}},
+		{"rules", "?sort=up", `{"name":"al","email":"Alice <alice@example.com>","tags":["a","go","db"],"items":[{"sku":"ABC123","quantity":3}]}`,
+			http.StatusBadRequest, []ValidationError{
+				{Field: "Name", Rule: "min", Param: "3", Message: "length must be at least 3"},
+				{Field: "Email", Rule: "email", Message: "must be a valid email address"},
+				{Field: "Sort", Rule: "oneof", Param: "asc desc", Message: "must be one of [asc desc]"},
+				{Field: "Tags", Rule: "max", Param: "2", Message: "length must be at most 2"},
+				{Field: "Items[0].Quantity", Rule: "even", Message: "failed on even"},
+			}},
+		{"nested", "", `{"name":"alice","tags":["a"],"items":[{"sku":"ABC","quantity":0}],"coupon":{"quantity":2}}`,
+			http.StatusBadRequest, []ValidationError{
+				{Field: "Tags[0]", Rule: "min", Param: "2", Message: "length must be at least 2"},
+				{Field: "Items[0].SKU", Rule: "len", Param: "6", Message: "length must be 6"},
+				{Field: "Items[0].Quantity", Rule: "min", Param: "1", Message: "must be at least 1"},
+				{Field: "Coupon.SKU", Rule: "required", Message: "is required"},
+			}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/orders"+tt.query, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		e.ServeHTTP(rw, r)
+		if rw.Code != tt.code {
+			t.Errorf("%s: code = %d, want %d, body %s", tt.name, rw.Code, tt.code, rw.Body.String())
+			continue
+		}
+		if tt.code == http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			Error  string            `json:"error"`
+			Fields []ValidationError `json:"fields"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: invalid response %s: %s", tt.name, rw.Body.String(), err)
+		}
+		if resp.Error != "validation failed" || !reflect.DeepEqual(resp.Fields, tt.fields) {
+			t.Errorf("%s: response = %+v, want fields %+v", tt.name, resp, tt.fields)
+		}
+	}
+}
+
+type validateTestNode struct {
+	Name     string `validate:"required"`
+	Parent   *validateTestNode
+	Children []*validateTestNode
+}
+
+func TestContext_validateCyclic(t *testing.T) {
+	root := &validateTestNode{Name: "root"}
+	child := &validateTestNode{Parent: root}
+	root.Parent = root
+	root.Children = []*validateTestNode{child, root}
+
+	c := NewContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	err := c.Validate(root)
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Field != "Children[0].Name" {
+		t.Errorf("Validate() of cyclic struct = %v, want error of Children[0].Name", err)
+	}
+}